@@ -0,0 +1,265 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+
+	carrierv1alpha1 "github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
+
+	configv1alpha1 "github.com/ocgi/carrier-webhook/pkg/apis/config/v1alpha1"
+	"github.com/ocgi/carrier-webhook/pkg/audit"
+	"github.com/ocgi/carrier-webhook/pkg/webhook"
+)
+
+// Complete loads the config file if set. Values of the flags set explicitly in fs are kept.
+func (s *ServerRunOptions) Complete(fs *pflag.FlagSet) error {
+	if s.ConfigFile == "" {
+		return nil
+	}
+	config, err := loadConfigFile(s.ConfigFile)
+	if err != nil {
+		return err
+	}
+	s.applyConfig(config, fs)
+	return nil
+}
+
+// loadConfigFile reads a WebhookConfiguration, unknown fields are rejected.
+func loadConfigFile(path string) (*configv1alpha1.WebhookConfiguration, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %v: %v", path, err)
+	}
+	config := &configv1alpha1.WebhookConfiguration{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("failed to decode config file %v: %v", path, err)
+	}
+	if config.APIVersion != configv1alpha1.SchemeGroupVersion.String() || config.Kind != configv1alpha1.Kind {
+		return nil, fmt.Errorf("config file %v must be %v %v, got %v %v", path,
+			configv1alpha1.SchemeGroupVersion, configv1alpha1.Kind, config.APIVersion, config.Kind)
+	}
+	return config, nil
+}
+
+// applyConfig sets the values of config not empty, unless the flag is set explicitly.
+func (s *ServerRunOptions) applyConfig(c *configv1alpha1.WebhookConfiguration, fs *pflag.FlagSet) {
+	set := func(flag string, isSet bool, apply func()) {
+		if isSet && !fs.Changed(flag) {
+			apply()
+		}
+	}
+	set("address", c.Server.Address != "", func() { s.Address = c.Server.Address })
+	set("port", c.Server.Port != 0, func() { s.Port = int(c.Server.Port) })
+	set("unhandled-kind-policy", c.Server.UnhandledKindPolicy != "", func() {
+		s.UnhandledKindPolicy = c.Server.UnhandledKindPolicy
+	})
+	set("tlscert", c.TLS.CertFile != "", func() { s.TlsCert = c.TLS.CertFile })
+	set("tlskey", c.TLS.KeyFile != "", func() { s.TlsKey = c.TLS.KeyFile })
+	set("tlsca", c.TLS.CAFile != "", func() { s.TlsCA = c.TLS.CAFile })
+	set("tls-reload-interval", c.TLS.ReloadInterval != nil, func() { s.TlsReloadInterval = c.TLS.ReloadInterval.Duration })
+	set("self-signed-certs", c.TLS.SelfSigned != nil, func() { s.SelfSignedCerts = *c.TLS.SelfSigned })
+	set("sidecar-image", c.SideCar.Image != "", func() { s.Image = c.SideCar.Image })
+	set("sidecar-cpu", c.SideCar.CPU != "", func() { s.CPU = c.SideCar.CPU })
+	set("sidecar-memory", c.SideCar.Memory != "", func() { s.Memory = c.SideCar.Memory })
+	set("sidecar-ephemeral-storage", c.SideCar.EphemeralStorage != "", func() {
+		s.EphemeralStorage = c.SideCar.EphemeralStorage
+	})
+	set("sidecar-cpu-limit", c.SideCar.Limits.CPU != "", func() { s.CPULimit = c.SideCar.Limits.CPU })
+	set("sidecar-memory-limit", c.SideCar.Limits.Memory != "", func() { s.MemoryLimit = c.SideCar.Limits.Memory })
+	set("sidecar-ephemeral-storage-limit", c.SideCar.Limits.EphemeralStorage != "", func() {
+		s.EphemeralStorageLimit = c.SideCar.Limits.EphemeralStorage
+	})
+	set("http-port", c.SideCar.HTTPPort != 0, func() { s.HttpPort = int(c.SideCar.HTTPPort) })
+	set("grpc-port", c.SideCar.GRPCPort != 0, func() { s.GrpcPort = int(c.SideCar.GRPCPort) })
+	set("sidecar-log-level", c.SideCar.LogLevel != nil, func() { s.SidecarLogLevel = int(*c.SideCar.LogLevel) })
+	set("sidecar-args", len(c.SideCar.Args) != 0, func() { s.SidecarArgs = c.SideCar.Args })
+	set("sidecar-token-audience", c.SideCar.Token.Audience != "", func() {
+		s.SidecarTokenAudience = c.SideCar.Token.Audience
+	})
+	set("sidecar-token-expiration", c.SideCar.Token.Expiration != nil, func() {
+		s.SidecarTokenExpiration = c.SideCar.Token.Expiration.Duration
+	})
+	set("sidecar-allowed-args", len(c.SideCar.AllowedArgs) != 0, func() { s.SidecarAllowedArgs = c.SideCar.AllowedArgs })
+	s.SidecarEnv = c.SideCar.Env
+	s.SidecarProbes = c.SideCar.Probes
+	set("sidecar-template-configmap", c.SideCar.TemplateConfigMap != "", func() {
+		s.SidecarTemplateConfigMap = c.SideCar.TemplateConfigMap
+	})
+	set("sidecar-injection-policy", c.SideCar.InjectionPolicy != "", func() {
+		s.SidecarInjectionPolicy = c.SideCar.InjectionPolicy
+	})
+	set("audit-log-path", c.Audit.Path != "", func() { s.AuditLogPath = c.Audit.Path })
+	set("audit-log-maxsize", c.Audit.MaxSizeMB != nil, func() { s.AuditLogMaxSize = int(*c.Audit.MaxSizeMB) })
+	set("audit-log-maxbackup", c.Audit.MaxBackups != nil, func() { s.AuditLogMaxBackups = int(*c.Audit.MaxBackups) })
+	set("audit-log-sample-rate", c.Audit.SampleRate != nil, func() { s.AuditLogSampleRate = *c.Audit.SampleRate })
+	set("audit-log-max-patch-bytes", c.Audit.MaxPatchBytes != nil, func() {
+		s.AuditLogMaxPatchBytes = int(*c.Audit.MaxPatchBytes)
+	})
+
+	serviceAccountName := s.Defaults.ServiceAccountName
+	s.Defaults = c.Defaults
+	if fs.Changed("default-service-account") || c.Defaults.ServiceAccountName == "" {
+		s.Defaults.ServiceAccountName = serviceAccountName
+	}
+}
+
+// Config returns the WebhookConfiguration of the options.
+func (s *ServerRunOptions) Config() *configv1alpha1.WebhookConfiguration {
+	selfSigned := s.SelfSignedCerts
+	maxSize, maxBackups := int32(s.AuditLogMaxSize), int32(s.AuditLogMaxBackups)
+	sampleRate, maxPatchBytes := s.AuditLogSampleRate, int32(s.AuditLogMaxPatchBytes)
+	logLevel := int32(s.SidecarLogLevel)
+	token := configv1alpha1.SideCarTokenConfiguration{
+		Audience:   s.SidecarTokenAudience,
+		Expiration: &metav1.Duration{Duration: s.SidecarTokenExpiration},
+	}
+	limits := configv1alpha1.SideCarLimitsConfiguration{
+		CPU:              s.CPULimit,
+		Memory:           s.MemoryLimit,
+		EphemeralStorage: s.EphemeralStorageLimit,
+	}
+	return &configv1alpha1.WebhookConfiguration{
+		Server: configv1alpha1.ServerConfiguration{
+			Address:             s.Address,
+			Port:                int32(s.Port),
+			UnhandledKindPolicy: s.UnhandledKindPolicy,
+		},
+		TLS: configv1alpha1.TLSConfiguration{
+			CertFile:       s.TlsCert,
+			KeyFile:        s.TlsKey,
+			CAFile:         s.TlsCA,
+			ReloadInterval: &metav1.Duration{Duration: s.TlsReloadInterval},
+			SelfSigned:     &selfSigned,
+		},
+		SideCar: configv1alpha1.SideCarConfiguration{
+			Image:             s.Image,
+			CPU:               s.CPU,
+			Memory:            s.Memory,
+			EphemeralStorage:  s.EphemeralStorage,
+			Limits:            limits,
+			HTTPPort:          int32(s.HttpPort),
+			GRPCPort:          int32(s.GrpcPort),
+			LogLevel:          &logLevel,
+			Probes:            s.SidecarProbes,
+			Token:             token,
+			Args:              s.SidecarArgs,
+			AllowedArgs:       s.SidecarAllowedArgs,
+			Env:               s.SidecarEnv,
+			TemplateConfigMap: s.SidecarTemplateConfigMap,
+			InjectionPolicy:   s.SidecarInjectionPolicy,
+		},
+		Defaults: s.Defaults,
+		Audit: configv1alpha1.AuditConfiguration{
+			Path:          s.AuditLogPath,
+			MaxSizeMB:     &maxSize,
+			MaxBackups:    &maxBackups,
+			SampleRate:    &sampleRate,
+			MaxPatchBytes: &maxPatchBytes,
+		},
+	}
+}
+
+// newAuditLogger creates the audit logger from options, nil if disabled.
+func newAuditLogger(s *ServerRunOptions) (*audit.Logger, error) {
+	if s.AuditLogPath == "" {
+		return nil, nil
+	}
+	return audit.NewLogger(audit.Options{
+		Path:          s.AuditLogPath,
+		MaxSize:       int64(s.AuditLogMaxSize) * 1024 * 1024,
+		MaxBackups:    s.AuditLogMaxBackups,
+		SampleRate:    s.AuditLogSampleRate,
+		MaxPatchBytes: s.AuditLogMaxPatchBytes,
+	})
+}
+
+// NewDefaults builds the defaults of mutating webhook from options, unset values keep the built-in ones.
+func NewDefaults(s *ServerRunOptions) *webhook.Defaults {
+	d := webhook.NewDefaults()
+	c := s.Defaults
+	if c.ServiceAccountName != "" {
+		d.ServiceAccountName = c.ServiceAccountName
+	}
+	if c.Scheduling != "" {
+		d.Scheduling = carrierv1alpha1.SchedulingStrategy(c.Scheduling)
+	}
+	if c.PortPolicy != "" {
+		d.PortPolicy = carrierv1alpha1.PortPolicy(c.PortPolicy)
+	}
+	if c.RevisionHistoryLimit != nil {
+		d.RevisionHistoryLimit = *c.RevisionHistoryLimit
+	}
+	if c.MaxSurge != nil {
+		d.MaxSurge = *c.MaxSurge
+	}
+	if c.MaxUnavailable != nil {
+		d.MaxUnavailable = *c.MaxUnavailable
+	}
+	return d
+}
+
+// newSideCarProbes merges the configured probes into the default ones and validates them
+func newSideCarProbes(c configv1alpha1.SideCarProbesConfiguration,
+	fldPath *field.Path) (webhook.SideCarProbes, field.ErrorList) {
+	probes := webhook.SideCarProbes{
+		Liveness:  newProbe(c.Liveness, true),
+		Readiness: newProbe(c.Readiness, false),
+		Startup:   newProbe(c.Startup, false),
+	}
+	errs := webhook.ValidateProbe(webhook.LivenessProbe, probes.Liveness, fldPath.Child("liveness"))
+	errs = append(errs, webhook.ValidateProbe(webhook.ReadinessProbe, probes.Readiness, fldPath.Child("readiness"))...)
+	errs = append(errs, webhook.ValidateProbe(webhook.StartupProbe, probes.Startup, fldPath.Child("startup"))...)
+	return probes, errs
+}
+
+// newProbe merges c into the default probe, nil is returned if the probe is disabled.
+// enabled decides whether the probe is enabled if c is nil.
+func newProbe(c *configv1alpha1.ProbeConfiguration, enabled bool) *webhook.ProbeConfig {
+	if c != nil {
+		enabled = c.Enabled == nil || *c.Enabled
+	}
+	if !enabled {
+		return nil
+	}
+	probe := webhook.DefaultProbe()
+	if c == nil {
+		return &probe
+	}
+	if c.Path != "" {
+		probe.Path = c.Path
+	}
+	for _, f := range []struct {
+		value *int32
+		field *int32
+	}{
+		{c.InitialDelaySeconds, &probe.InitialDelaySeconds},
+		{c.TimeoutSeconds, &probe.TimeoutSeconds},
+		{c.PeriodSeconds, &probe.PeriodSeconds},
+		{c.SuccessThreshold, &probe.SuccessThreshold},
+		{c.FailureThreshold, &probe.FailureThreshold},
+	} {
+		if f.value != nil {
+			*f.field = *f.value
+		}
+	}
+	return &probe
+}
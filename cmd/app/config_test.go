@@ -0,0 +1,166 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	carrierv1alpha1 "github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
+)
+
+const testConfig = `apiVersion: webhook.carrier.ocgi.dev/v1alpha1
+kind: WebhookConfiguration
+server:
+  port: 8443
+tls:
+  reloadInterval: 30s
+sidecar:
+  image: ocgi/carrier-sdkserver:v0.1.0
+  cpu: 200m
+  limits:
+    cpu: "1"
+  args:
+  - --feature-gates=xx
+  allowedArgs:
+  - feature-gates
+  env:
+  - name: SDK_MODE
+    value: fast
+  probes:
+    liveness:
+      periodSeconds: 5
+    readiness:
+      path: /ready
+defaults:
+  serviceAccountName: game-sdk
+  scheduling: LeastAllocated
+  maxSurge: 1
+`
+
+func newTestOptions(t *testing.T, config string, args ...string) (*ServerRunOptions, error) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	s := &ServerRunOptions{}
+	s.addFlags(fs)
+	if err := fs.Parse(append([]string{"--config", path}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	return s, s.Complete(fs)
+}
+
+func TestComplete(t *testing.T) {
+	s, err := newTestOptions(t, testConfig, "--sidecar-cpu=500m", "--default-service-account=override")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Port != 8443 || s.TlsReloadInterval.String() != "30s" || s.Image != "ocgi/carrier-sdkserver:v0.1.0" {
+		t.Errorf("file values are not applied: %v %v %v", s.Port, s.TlsReloadInterval, s.Image)
+	}
+	if s.CPU != "500m" || s.Defaults.ServiceAccountName != "override" {
+		t.Errorf("flags must override file values, got cpu %v, service account %v", s.CPU,
+			s.Defaults.ServiceAccountName)
+	}
+	if s.Address != "0.0.0.0" || s.Memory != "100M" {
+		t.Errorf("flag defaults must be kept if not set in file, got %v %v", s.Address, s.Memory)
+	}
+	if !reflect.DeepEqual(s.SidecarArgs, []string{"--feature-gates=xx"}) {
+		t.Errorf("unexpected side car args %v", s.SidecarArgs)
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewSideCarConfig(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CPU.String() != "500m" || config.CPULimit.String() != "1" || !config.MemoryLimit.IsZero() {
+		t.Errorf("unexpected resources %v %v %v", config.CPU, config.CPULimit, config.MemoryLimit)
+	}
+	if len(config.Env) != 1 || config.Env[0].Value != "fast" ||
+		!reflect.DeepEqual(s.SidecarAllowedArgs, []string{"feature-gates"}) {
+		t.Errorf("unexpected env %v and allowed args %v", config.Env, s.SidecarAllowedArgs)
+	}
+	probes := config.Probes
+	if probes.Liveness == nil || probes.Liveness.PeriodSeconds != 5 || probes.Liveness.Path != "/healthz" ||
+		probes.Readiness == nil || probes.Readiness.Path != "/ready" || probes.Startup != nil {
+		t.Errorf("unexpected probes %+v %+v %+v", probes.Liveness, probes.Readiness, probes.Startup)
+	}
+	d := NewDefaults(s)
+	if d.ServiceAccountName != "override" || d.Scheduling != carrierv1alpha1.LeastAllocated ||
+		d.MaxSurge != intstr.FromInt(1) || d.MaxUnavailable != intstr.FromString("25%") ||
+		d.PortPolicy != carrierv1alpha1.LoadBalancer {
+		t.Errorf("unexpected defaults %+v", d)
+	}
+}
+
+func TestCompleteInvalidFile(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{
+			name:   "unknown field",
+			config: testConfig + "unknown: true\n",
+			err:    "unknown field",
+		},
+		{
+			name:   "wrong kind",
+			config: strings.Replace(testConfig, "kind: WebhookConfiguration", "kind: Config", 1),
+			err:    "must be webhook.carrier.ocgi.dev/v1alpha1 WebhookConfiguration",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := newTestOptions(t, c.config)
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("desired error %q, got %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestValidateAggregated(t *testing.T) {
+	config := strings.Replace(testConfig, "periodSeconds: 5", "periodSeconds: 5\n      timeoutSeconds: 6", 1)
+	s, err := newTestOptions(t, config, "--sidecar-cpu=abc", "--sidecar-image=", "--grpc-port=9021",
+		"--webhook-timeout-seconds=60", "--sidecar-token-expiration=1m", "--sidecar-memory-limit=10M",
+		"--sidecar-allowed-args=v", "--unhandled-kind-policy=Reject", "--sidecar-injection-policy=Never")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Validate()
+	if err == nil {
+		t.Fatal("desired errors, got nil")
+	}
+	for _, msg := range []string{"sidecar.cpu", "sidecar.image", "sidecar.grpcPort", "sidecar.token.expiration",
+		"sidecar.limits.memory", "sidecar.allowedArgs[0]", "sidecar.probes.liveness.timeoutSeconds",
+		"server.unhandledKindPolicy", "sidecar.injectionPolicy", "webhook timeout seconds"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("desired error of %v, got %v", msg, err)
+		}
+	}
+	if _, err := NewSideCarConfig(s); err == nil {
+		t.Error("desired error of invalid cpu, got nil")
+	}
+}
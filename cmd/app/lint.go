@@ -0,0 +1,82 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/spf13/pflag"
+
+	"github.com/ocgi/carrier-webhook/pkg/lint"
+	"github.com/ocgi/carrier-webhook/pkg/manifest"
+)
+
+// ErrLintFailed is returned by RunLint if any document is invalid
+var ErrLintFailed = errors.New("lint failed")
+
+// RunLint runs the lint subcommand, args exclude the subcommand name.
+func RunLint(args []string, out io.Writer) error {
+	fs := pflag.NewFlagSet("lint", pflag.ContinueOnError)
+	s := &ServerRunOptions{}
+	s.addMutationFlags(fs)
+	var files, olds, namespaceFiles []string
+	var output string
+	fs.StringSliceVarP(&files, "filename", "f", nil,
+		"Files or directories of GameServer, GameServerSet and Squad manifests, directories are walked recursively")
+	fs.StringSliceVar(&olds, "old", nil,
+		"Files or directories of the old versions, documents with the same kind, namespace and name are validated as updates")
+	fs.StringSliceVar(&namespaceFiles, "namespaces", nil,
+		"Files or directories of Namespace manifests, their side car overrides are applied to the objects in them")
+	fs.StringVarP(&output, "output", "o", lint.OutputText, fmt.Sprintf("Output format, one of %v", lint.Outputs))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	files = append(files, fs.Args()...)
+	if len(files) == 0 {
+		return fmt.Errorf("no manifests, set them with -f")
+	}
+	if err := s.Complete(fs); err != nil {
+		return err
+	}
+	config, err := NewSideCarConfig(s)
+	if err != nil {
+		return err
+	}
+
+	docs, err := manifest.LoadFiles(files, manifest.CarrierKinds)
+	if err != nil {
+		return err
+	}
+	oldDocs, err := manifest.LoadFiles(olds, manifest.CarrierKinds)
+	if err != nil {
+		return err
+	}
+	namespaces, err := loadNamespaces(namespaceFiles)
+	if err != nil {
+		return err
+	}
+	results := lint.Lint(docs, oldDocs, config, NewDefaults(s), namespaces)
+	if err := lint.WriteReport(out, output, results); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Failed() {
+			return ErrLintFailed
+		}
+	}
+	return nil
+}
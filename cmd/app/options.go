@@ -16,9 +16,16 @@ package app
 
 import (
 	"fmt"
-	"net"
+	"time"
 
 	"github.com/spf13/pflag"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	configv1alpha1 "github.com/ocgi/carrier-webhook/pkg/apis/config/v1alpha1"
+	"github.com/ocgi/carrier-webhook/pkg/webhook"
 )
 
 var (
@@ -35,6 +42,52 @@ type ServerRunOptions struct {
 	TlsCA   string
 	TlsCert string
 	TlsKey  string
+	// TlsReloadInterval is the interval of checking TLS files for changes
+	TlsReloadInterval time.Duration
+	// SelfSignedCerts generates and rotates serving certificates by the webhook itself
+	SelfSignedCerts bool
+	// CertSecretName is the secret storing self-signed certificates, in ServiceNamespace
+	CertSecretName string
+	// CertValidity is the validity of self-signed serving certificate
+	CertValidity time.Duration
+	// CertRotateBefore rotates self-signed certificates when they expire within it
+	CertRotateBefore time.Duration
+	// CertCheckInterval is the interval of checking self-signed certificates
+	CertCheckInterval time.Duration
+	// ServiceName is the name of webhook service
+	ServiceName string
+	// ServiceNamespace is the namespace of webhook service
+	ServiceNamespace string
+	// MutatingWebhookName is the name of MutatingWebhookConfiguration
+	MutatingWebhookName string
+	// ValidatingWebhookName is the name of ValidatingWebhookConfiguration
+	ValidatingWebhookName string
+	// ServicePort is the port of webhook service
+	ServicePort int
+	// ManageWebhookConfigurations creates and updates the webhook configurations by the leader
+	ManageWebhookConfigurations bool
+	// CABundleFile is the CA bundle injected into webhook configurations
+	CABundleFile string
+	// WebhookFailurePolicy is the failure policy of webhook configurations
+	WebhookFailurePolicy string
+	// WebhookTimeoutSeconds is the timeout of webhook configurations
+	WebhookTimeoutSeconds int
+	// WebhookResyncPeriod is the interval of fixing drift of webhook configurations
+	WebhookResyncPeriod time.Duration
+	// UnhandledKindPolicy decides the requests of kinds without handler
+	UnhandledKindPolicy string
+	// Uninstall deletes the webhook configurations and exits
+	Uninstall bool
+	// Kubeconfig is the path to kubeconfig, in-cluster config is used if empty
+	Kubeconfig string
+	// Master overrides the address of apiserver in kubeconfig
+	Master string
+	// Context is the kubeconfig context to use
+	Context string
+	// KubeAPIQPS is the QPS of kube client
+	KubeAPIQPS float32
+	// KubeAPIBurst is the burst of kube client
+	KubeAPIBurst int
 	// ShowVersion prints version if true
 	ShowVersion bool
 	// HttpPort of side car
@@ -47,34 +100,168 @@ type ServerRunOptions struct {
 	CPU string
 	//Memory of side car
 	Memory string
+	// EphemeralStorage is the ephemeral storage request of side car
+	EphemeralStorage string
+	// CPULimit is the cpu limit of side car, the request if empty
+	CPULimit string
+	// MemoryLimit is the memory limit of side car, the request if empty
+	MemoryLimit string
+	// EphemeralStorageLimit is the ephemeral storage limit of side car, the request if empty
+	EphemeralStorageLimit string
+	// SidecarLogLevel is the klog verbosity of side car
+	SidecarLogLevel int
+	// SidecarArgs are the extra args of side car
+	SidecarArgs []string
+	// SidecarTokenAudience is the audience of the projected token injected for side car
+	SidecarTokenAudience string
+	// SidecarTokenExpiration is the expiration of the projected token injected for side car
+	SidecarTokenExpiration time.Duration
+	// SidecarAllowedArgs are the flags of side car allowed in the args annotation
+	SidecarAllowedArgs []string
+	// SidecarEnv is the extra env of side car, only set by the config file
+	SidecarEnv []corev1.EnvVar
+	// SidecarProbes are the probes of side car, only set by the config file
+	SidecarProbes configv1alpha1.SideCarProbesConfiguration
+	// SidecarTemplateConfigMap is the namespace/name of the ConfigMap of side car template
+	SidecarTemplateConfigMap string
+	// SidecarInjectionPolicy decides the injection of pods without the inject annotation
+	SidecarInjectionPolicy string
+	// Defaults are the values set by the mutating webhook
+	Defaults configv1alpha1.DefaultsConfiguration
+	// AuditLogPath is the path of audit log, "-" for stdout, disabled if empty
+	AuditLogPath string
+	// AuditLogMaxSize is the size in megabytes rotating the audit log
+	AuditLogMaxSize int
+	// AuditLogMaxBackups is the number of rotated audit logs to keep
+	AuditLogMaxBackups int
+	// AuditLogSampleRate is the fraction of allowed requests recorded
+	AuditLogSampleRate float64
+	// AuditLogMaxPatchBytes caps the patch recorded
+	AuditLogMaxPatchBytes int
+	// ConfigFile is the path to the WebhookConfiguration file, flags override its values
+	ConfigFile string
 }
 
 // NewServerRunOptions creates new run options
 func NewServerRunOptions() *ServerRunOptions {
 	options := &ServerRunOptions{}
-	options.addFlags()
+	options.addFlags(pflag.CommandLine)
 	return options
 }
 
-func (s *ServerRunOptions) addFlags() {
-	pflag.StringVar(&s.Address, "address", "0.0.0.0", "The address of webhoook.")
-	pflag.IntVar(&s.Port, "port", 8080, "The port of scheduler manager.")
-	pflag.StringVar(&s.TlsCert, "tlscert", "", "Path to TLS certificate file")
-	pflag.StringVar(&s.TlsKey, "tlskey", "", "Path to TLS key file")
-	pflag.StringVar(&s.TlsCA, "tlsca", "", "Path to certificate file")
-	pflag.BoolVar(&s.ShowVersion, "version", false, "Show version.")
-	pflag.IntVar(&s.HttpPort, "http-port", 9021, "http port for side car.")
-	pflag.IntVar(&s.GrpcPort, "grpc-port", 9020, "grpc port for side car.")
-	pflag.StringVar(&s.Image, "sidecar-image", "9021", "grpc port for side car.")
-	pflag.StringVar(&s.CPU, "sidecar-cpu", "100m", "grpc port for side car.")
-	pflag.StringVar(&s.Memory, "sidecar-memory", "100M", "grpc port for side car.")
+func (s *ServerRunOptions) addFlags(fs *pflag.FlagSet) {
+	s.addMutationFlags(fs)
+	fs.StringVar(&s.Address, "address", "0.0.0.0", "The address of webhoook.")
+	fs.IntVar(&s.Port, "port", 8080, "The port of scheduler manager.")
+	fs.StringVar(&s.SidecarTemplateConfigMap, "sidecar-template-configmap", "",
+		"The namespace/name of the ConfigMap whose container template in key "+webhook.SideCarTemplateKey+
+			" is merged into side car, watched for changes.")
+	fs.StringVar(&s.UnhandledKindPolicy, "unhandled-kind-policy", string(webhook.UnhandledKindAllow),
+		"Decide the requests of kinds without handler, Allow or Deny.")
+	fs.StringVar(&s.TlsCert, "tlscert", "", "Path to TLS certificate file")
+	fs.StringVar(&s.TlsKey, "tlskey", "", "Path to TLS key file")
+	fs.StringVar(&s.TlsCA, "tlsca", "", "Path to certificate file")
+	fs.DurationVar(&s.TlsReloadInterval, "tls-reload-interval", 10*time.Second,
+		"Interval of checking the TLS certificate, key and CA files for changes.")
+	fs.BoolVar(&s.SelfSignedCerts, "self-signed-certs", false,
+		"Generate and rotate serving certificates, and inject CA bundle into webhook configurations.")
+	fs.StringVar(&s.CertSecretName, "cert-secret-name", "carrier-webhook-certs",
+		"The secret storing self-signed certificates, in the namespace of service.")
+	fs.DurationVar(&s.CertValidity, "cert-validity", 365*24*time.Hour, "Validity of self-signed serving certificate.")
+	fs.DurationVar(&s.CertRotateBefore, "cert-rotate-before", 30*24*time.Hour,
+		"Rotate self-signed certificates when they expire within this duration.")
+	fs.DurationVar(&s.CertCheckInterval, "cert-check-interval", time.Hour,
+		"Interval of checking self-signed certificates.")
+	fs.StringVar(&s.ServiceName, "service-name", "carrier-webhook-service", "The name of webhook service.")
+	fs.StringVar(&s.ServiceNamespace, "service-namespace", "kube-system", "The namespace of webhook service.")
+	fs.StringVar(&s.MutatingWebhookName, "mutating-webhook-name", "carrier-mutator",
+		"The name of MutatingWebhookConfiguration.")
+	fs.StringVar(&s.ValidatingWebhookName, "validating-webhook-name", "carrier-validator",
+		"The name of ValidatingWebhookConfiguration.")
+	fs.IntVar(&s.ServicePort, "service-port", 443, "The port of webhook service.")
+	fs.BoolVar(&s.ManageWebhookConfigurations, "manage-webhook-configurations", false,
+		"Create the webhook configurations on startup and fix drift by the leader.")
+	fs.StringVar(&s.CABundleFile, "ca-bundle", "",
+		"Path to the CA bundle injected into webhook configurations, not required by --self-signed-certs.")
+	fs.StringVar(&s.WebhookFailurePolicy, "webhook-failure-policy", string(admissionregistrationv1.Ignore),
+		"Failure policy of webhook configurations, Ignore or Fail.")
+	fs.IntVar(&s.WebhookTimeoutSeconds, "webhook-timeout-seconds", 10, "Timeout of webhook configurations.")
+	fs.DurationVar(&s.WebhookResyncPeriod, "webhook-resync-period", time.Minute,
+		"Interval of fixing drift of webhook configurations.")
+	fs.BoolVar(&s.Uninstall, "uninstall", false, "Delete the webhook configurations and exit.")
+	fs.StringVar(&s.Kubeconfig, "kubeconfig", "",
+		"Path to kubeconfig. The in-cluster config is used if none of --kubeconfig, --master and --context is set.")
+	fs.StringVar(&s.Master, "master", "", "The address of apiserver, overrides the one in kubeconfig.")
+	fs.StringVar(&s.Context, "context", "", "The kubeconfig context to use.")
+	fs.Float32Var(&s.KubeAPIQPS, "kube-api-qps", 20, "QPS of the kube client.")
+	fs.IntVar(&s.KubeAPIBurst, "kube-api-burst", 30, "Burst of the kube client.")
+	fs.BoolVar(&s.ShowVersion, "version", false, "Show version.")
+	fs.StringVar(&s.AuditLogPath, "audit-log-path", "",
+		"Path of the audit log recording every admission decision, - for stdout. Disabled if empty.")
+	fs.IntVar(&s.AuditLogMaxSize, "audit-log-maxsize", 100, "Size in megabytes rotating the audit log, 0 disables rotation.")
+	fs.IntVar(&s.AuditLogMaxBackups, "audit-log-maxbackup", 10, "Number of rotated audit logs to keep.")
+	fs.Float64Var(&s.AuditLogSampleRate, "audit-log-sample-rate", 1,
+		"Fraction of allowed requests recorded in the audit log, denied requests are always recorded.")
+	fs.IntVar(&s.AuditLogMaxPatchBytes, "audit-log-max-patch-bytes", 64*1024,
+		"Patches larger than this are truncated in the audit log, 0 records the whole patch.")
+}
+
+// addMutationFlags adds the flags of the config file, side car and defaults, shared by the render subcommand.
+func (s *ServerRunOptions) addMutationFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&s.ConfigFile, "config", "",
+		"Path to the WebhookConfiguration file, the flags set explicitly override its values.")
+	fs.IntVar(&s.HttpPort, "http-port", 9021, "http port for side car.")
+	fs.IntVar(&s.GrpcPort, "grpc-port", 9020, "grpc port for side car.")
+	fs.StringVar(&s.Image, "sidecar-image", "ocgi/carrier-sdkserver:latest", "image of side car.")
+	fs.StringVar(&s.CPU, "sidecar-cpu", "100m", "cpu of side car.")
+	fs.StringVar(&s.Memory, "sidecar-memory", "100M", "memory of side car.")
+	fs.StringVar(&s.EphemeralStorage, "sidecar-ephemeral-storage", "", "ephemeral storage of side car.")
+	fs.StringVar(&s.CPULimit, "sidecar-cpu-limit", "", "cpu limit of side car, --sidecar-cpu if empty.")
+	fs.StringVar(&s.MemoryLimit, "sidecar-memory-limit", "", "memory limit of side car, --sidecar-memory if empty.")
+	fs.StringVar(&s.EphemeralStorageLimit, "sidecar-ephemeral-storage-limit", "",
+		"ephemeral storage limit of side car, --sidecar-ephemeral-storage if empty.")
+	fs.IntVar(&s.SidecarLogLevel, "sidecar-log-level", 5, "log level of side car.")
+	fs.StringVar(&s.SidecarTokenAudience, "sidecar-token-audience", "",
+		"Audience of the projected service account token injected if the pod has none, the apiserver audience if empty.")
+	fs.DurationVar(&s.SidecarTokenExpiration, "sidecar-token-expiration",
+		time.Duration(webhook.DefaultTokenExpirationSeconds)*time.Second,
+		"Expiration of the projected service account token injected if the pod has none, at least 10m.")
+	fs.StringSliceVar(&s.SidecarArgs, "sidecar-args", nil, "extra args of side car, e.g. --feature-gates=xx.")
+	fs.StringSliceVar(&s.SidecarAllowedArgs, "sidecar-allowed-args", nil,
+		"Flags of side car allowed in the "+webhook.SideCarArgsKey+" annotation of namespaces and pods, e.g. feature-gates.")
+	fs.StringVar(&s.SidecarInjectionPolicy, "sidecar-injection-policy", string(webhook.InjectionOptOut),
+		"Injection of game server pods without the "+webhook.InjectKey+" label or annotation, "+
+			"OptOut injects them and OptIn skips them.")
+	fs.StringVar(&s.Defaults.ServiceAccountName, "default-service-account", "",
+		"The service account of game server pods if not set, created if not exists. Default is carrier-sdk.")
 }
 
-// Validate address
+// Validate validates all the options, all the errors are returned.
 func (s *ServerRunOptions) Validate() error {
-	address := net.ParseIP(s.Address)
-	if address.To4() == nil {
-		return fmt.Errorf("%v is not a valid IP address\n", s.Address)
+	var errs []error
+	for _, err := range configv1alpha1.ValidateWebhookConfiguration(s.Config()) {
+		errs = append(errs, err)
+	}
+	_, probeErrs := newSideCarProbes(s.SidecarProbes, field.NewPath("sidecar", "probes"))
+	for _, err := range probeErrs {
+		errs = append(errs, err)
+	}
+	if s.KubeAPIQPS <= 0 || s.KubeAPIBurst <= 0 {
+		errs = append(errs, fmt.Errorf("--kube-api-qps and --kube-api-burst must be positive"))
+	}
+	if s.ServicePort <= 0 || s.ServicePort > 65535 {
+		errs = append(errs, fmt.Errorf("%v is not a valid service port", s.ServicePort))
+	}
+	policy := admissionregistrationv1.FailurePolicyType(s.WebhookFailurePolicy)
+	if policy != admissionregistrationv1.Ignore && policy != admissionregistrationv1.Fail {
+		errs = append(errs, fmt.Errorf("webhook failure policy must be %v or %v", admissionregistrationv1.Ignore,
+			admissionregistrationv1.Fail))
+	}
+	if s.WebhookTimeoutSeconds < 1 || s.WebhookTimeoutSeconds > 30 {
+		errs = append(errs, fmt.Errorf("webhook timeout seconds must be between 1 and 30"))
+	}
+	if s.SelfSignedCerts && s.CertRotateBefore >= s.CertValidity {
+		errs = append(errs, fmt.Errorf("--cert-rotate-before must be less than --cert-validity"))
 	}
-	return nil
+	return utilerrors.NewAggregate(errs)
 }
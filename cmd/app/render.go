@@ -0,0 +1,104 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/ocgi/carrier-webhook/pkg/manifest"
+	"github.com/ocgi/carrier-webhook/pkg/render"
+	"github.com/ocgi/carrier-webhook/pkg/webhook"
+)
+
+// RunRender runs the render subcommand, args exclude the subcommand name.
+func RunRender(args []string, out io.Writer) error {
+	fs := pflag.NewFlagSet("render", pflag.ContinueOnError)
+	s := &ServerRunOptions{}
+	s.addMutationFlags(fs)
+	var files, namespaceFiles []string
+	var output, template string
+	var pod bool
+	fs.StringSliceVarP(&files, "filename", "f", nil,
+		"Files or directories of Pod, GameServer, GameServerSet and Squad manifests, directories are walked recursively")
+	fs.StringVarP(&output, "output", "o", render.OutputObject, fmt.Sprintf("Output format, one of %v", render.Outputs))
+	fs.StringVar(&template, "sidecar-template", "",
+		"File of the container template merged into side car, like key "+webhook.SideCarTemplateKey+" of the ConfigMap")
+	fs.StringSliceVar(&namespaceFiles, "namespaces", nil,
+		"Files or directories of Namespace manifests, their side car overrides are applied to the objects in them")
+	fs.BoolVar(&pod, "pod", false, "Render the pod of GameServer, GameServerSet and Squad templates with the side car injected")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	files = append(files, fs.Args()...)
+	if len(files) == 0 {
+		return fmt.Errorf("no manifests, set them with -f")
+	}
+	if err := s.Complete(fs); err != nil {
+		return err
+	}
+	config, err := NewSideCarConfig(s)
+	if err != nil {
+		return err
+	}
+	if template != "" {
+		data, err := ioutil.ReadFile(template)
+		if err != nil {
+			return err
+		}
+		if config.Template, err = webhook.ParseSideCarTemplate(data, config.AllowedArgs); err != nil {
+			return err
+		}
+	}
+	defaults := NewDefaults(s)
+
+	docs, err := manifest.LoadFiles(files, manifest.PodKinds)
+	if err != nil {
+		return err
+	}
+	namespaces, err := loadNamespaces(namespaceFiles)
+	if err != nil {
+		return err
+	}
+	var rendered []*render.Rendered
+	for _, doc := range docs {
+		r, err := render.Render(doc, config, defaults, namespaces[doc.Namespace], pod)
+		if err != nil {
+			return err
+		}
+		rendered = append(rendered, r)
+	}
+	return render.Write(out, output, rendered)
+}
+
+// loadNamespaces returns the namespaces of files keyed by name
+func loadNamespaces(files []string) (map[string]*corev1.Namespace, error) {
+	docs, err := manifest.LoadFiles(files, manifest.NamespaceKinds)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := map[string]*corev1.Namespace{}
+	for _, doc := range docs {
+		if doc.DecodeErr != nil {
+			return nil, fmt.Errorf("decode %v failed: %v", doc, doc.DecodeErr)
+		}
+		namespaces[doc.Name] = doc.Object.(*corev1.Namespace)
+	}
+	return namespaces, nil
+}
@@ -17,7 +17,6 @@ package app
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -26,41 +25,80 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/klog"
 
+	"github.com/ocgi/carrier-webhook/pkg/cert"
+	"github.com/ocgi/carrier-webhook/pkg/healthz"
+	"github.com/ocgi/carrier-webhook/pkg/metrics"
+	"github.com/ocgi/carrier-webhook/pkg/registration"
 	"github.com/ocgi/carrier-webhook/pkg/util"
 	"github.com/ocgi/carrier-webhook/pkg/webhook"
 )
 
-// Run runs the webhook
-func Run(s *ServerRunOptions) error {
+// RegisterFunc registers the handlers of more kinds, it is called before serving
+type RegisterFunc func(registry *webhook.Registry) error
+
+// Run runs the webhook, registers add handlers besides the built-in ones
+func Run(s *ServerRunOptions, registers ...RegisterFunc) error {
 	stopCh := util.SetupSignalHandler()
 
-	config, err := rest.InClusterConfig()
+	config, err := buildKubeConfig(s)
 	if err != nil {
-		klog.Fatal("Build kube config failed")
+		return fmt.Errorf("build kube config failed: %v", err)
 	}
 
-	client := kubernetes.NewForConfigOrDie(config)
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("build kube client failed: %v", err)
+	}
+	sideCarConfig, err := NewSideCarConfig(s)
+	if err != nil {
+		return err
+	}
 	coreFactory := informers.NewSharedInformerFactory(client, 0)
-	wh := webhook.NewWebhookServer(NewSideCarConfig(s), client, coreFactory)
-
-	coreFactory.Start(stopCh)
-	wh.WaitForCacheSynced(stopCh)
+	wh, err := webhook.NewWebhookServer(sideCarConfig, NewDefaults(s), client, coreFactory)
+	if err != nil {
+		return err
+	}
+	for _, register := range registers {
+		if err := register(wh.Registry()); err != nil {
+			return fmt.Errorf("register handlers failed: %v", err)
+		}
+	}
+	auditLogger, err := newAuditLogger(s)
+	if err != nil {
+		return fmt.Errorf("open audit log failed: %v", err)
+	}
+	defer auditLogger.Close()
+	wh.SetAuditLogger(auditLogger)
+	wh.Registry().UnhandledKindPolicy = webhook.UnhandledKindPolicy(s.UnhandledKindPolicy)
+	var templateFactory informers.SharedInformerFactory
+	if s.SidecarTemplateConfigMap != "" {
+		namespace, name, _ := cache.SplitMetaNamespaceKey(s.SidecarTemplateConfigMap)
+		var watcher *webhook.SideCarTemplateWatcher
+		watcher, templateFactory = webhook.NewSideCarTemplateWatcher(client, namespace, name, sideCarConfig.AllowedArgs)
+		wh.SetSideCarTemplateWatcher(watcher)
+	}
 
 	// Start debug monitor.
 	mux := http.NewServeMux()
-	mux.HandleFunc("/mutate", wh.Serve)
+	mux.HandleFunc(webhook.MutatePath, wh.Serve)
+	mux.HandleFunc(webhook.ValidatePath, wh.Serve)
+	metrics.Register()
+	mux.Handle("/metrics", promhttp.Handler())
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
 	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
 	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, "%s", "ok")
-	})
 
 	server := &http.Server{
 		Addr:         net.JoinHostPort(s.Address, strconv.Itoa(s.Port)),
@@ -70,15 +108,45 @@ func Run(s *ServerRunOptions) error {
 	}
 
 	klog.V(1).Infof("listening on %v", server.Addr)
-	if s.TlsCert != "" && s.TlsKey != "" {
+	caBundle := readCABundle(s)
+	var store *cert.Store
+	if s.SelfSignedCerts {
+		klog.V(1).Infof("using HTTPS service with self-signed certificates")
+		store = cert.NewStore()
+		signer := cert.NewSelfSigner(client, store, cert.SelfSignedOptions{
+			SecretName:            s.CertSecretName,
+			SecretNamespace:       s.ServiceNamespace,
+			ServiceName:           s.ServiceName,
+			ServiceNamespace:      s.ServiceNamespace,
+			MutatingWebhookName:   s.MutatingWebhookName,
+			ValidatingWebhookName: s.ValidatingWebhookName,
+			Validity:              s.CertValidity,
+			RotateBefore:          s.CertRotateBefore,
+			CheckInterval:         s.CertCheckInterval,
+		})
+		if err := signer.Ensure(); err != nil {
+			return err
+		}
+		go signer.Run(stopCh)
+		caBundle = signer.CABundle
+	} else if s.TlsCert != "" && s.TlsKey != "" {
 		klog.V(1).Infof("using HTTPS service")
-		tlsConfig, err := getTLSConfig(s)
-		if err != nil {
+		store = cert.NewStore()
+		watcher := cert.NewFileWatcher(store, s.TlsCert, s.TlsKey, s.TlsCA, s.TlsReloadInterval)
+		if err := watcher.Load(); err != nil {
 			return err
 		}
-		server.TLSConfig = tlsConfig
+		go watcher.Run(stopCh)
+	}
+
+	healthz.InstallHandler(mux, "/healthz", healthz.PingCheck)
+	healthz.InstallHandler(mux, "/livez", healthz.PingCheck)
+	healthz.InstallHandler(mux, "/readyz", readyChecks(wh, store)...)
+
+	if store != nil {
+		server.TLSConfig = getTLSConfig(s, store)
 		go func() {
-			klog.Fatal(server.ListenAndServeTLS(s.TlsCert, s.TlsKey))
+			klog.Fatal(server.ListenAndServeTLS("", ""))
 		}()
 	} else {
 		go func() {
@@ -87,6 +155,21 @@ func Run(s *ServerRunOptions) error {
 		}()
 	}
 
+	// the server is not ready until the informers have synced
+	coreFactory.Start(stopCh)
+	if templateFactory != nil {
+		templateFactory.Start(stopCh)
+	}
+	wh.WaitForCacheSynced(stopCh)
+
+	if s.ManageWebhookConfigurations {
+		options := newRegistrationOptions(s)
+		options.MutatingRules = wh.Registry().MutatingRules()
+		options.ValidatingRules = wh.Registry().ValidatingRules()
+		reconciler := registration.NewReconciler(client, options, caBundle)
+		go runLeaderElected(client, s.ServiceNamespace, stopCh, reconciler.Run)
+	}
+
 	select {
 	case <-stopCh:
 		klog.Info("http server received stop signal, waiting for all requests to finish")
@@ -99,36 +182,156 @@ func Run(s *ServerRunOptions) error {
 	return nil
 }
 
-func getTLSConfig(s *ServerRunOptions) (*tls.Config, error) {
-	tlsConfig := &tls.Config{
-		NextProtos: []string{"http/1.1"},
-		//		Certificates: []tls.Certificate{cert},
-		// Avoid fallback on insecure SSL protocols
-		MinVersion: tls.VersionTLS10,
+// readyChecks returns the checks of /readyz, the certificate is checked if serving HTTPS
+func readyChecks(wh webhookChecker, store *cert.Store) []healthz.Checker {
+	checks := []healthz.Checker{
+		healthz.NamedCheck("informer-sync", wh.CheckInformerSync),
+		healthz.NamedCheck("cluster-role", wh.CheckClusterRole),
 	}
-	if s.TlsCA != "" {
-		certPool := x509.NewCertPool()
-		file, err := ioutil.ReadFile(s.TlsCA)
+	if store != nil {
+		checks = append(checks, healthz.NamedCheck("certificate", func(*http.Request) error {
+			return store.Check()
+		}))
+	}
+	return checks
+}
+
+// webhookChecker is the readiness checks of webhook server
+type webhookChecker interface {
+	CheckInformerSync(r *http.Request) error
+	CheckClusterRole(r *http.Request) error
+}
+
+// Uninstall deletes the webhook configurations
+func Uninstall(s *ServerRunOptions) error {
+	config, err := buildKubeConfig(s)
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	return registration.NewReconciler(client, newRegistrationOptions(s), nil).Delete()
+}
+
+// buildKubeConfig uses the in-cluster config by default, or loads the kubeconfig
+// if any of --kubeconfig, --master and --context is set.
+func buildKubeConfig(s *ServerRunOptions) (*rest.Config, error) {
+	var config *rest.Config
+	var err error
+	if s.Kubeconfig == "" && s.Master == "" && s.Context == "" {
+		config, err = rest.InClusterConfig()
+	} else {
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		rules.ExplicitPath = s.Kubeconfig
+		overrides := &clientcmd.ConfigOverrides{
+			CurrentContext: s.Context,
+			ClusterInfo:    clientcmdapi.Cluster{Server: s.Master},
+		}
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	config.QPS = s.KubeAPIQPS
+	config.Burst = s.KubeAPIBurst
+	return rest.AddUserAgent(config, "carrier-webhook"), nil
+}
+
+func newRegistrationOptions(s *ServerRunOptions) registration.Options {
+	return registration.Options{
+		MutatingWebhookName:   s.MutatingWebhookName,
+		ValidatingWebhookName: s.ValidatingWebhookName,
+		ServiceName:           s.ServiceName,
+		ServiceNamespace:      s.ServiceNamespace,
+		ServicePort:           int32(s.ServicePort),
+		FailurePolicy:         admissionregistrationv1.FailurePolicyType(s.WebhookFailurePolicy),
+		TimeoutSeconds:        int32(s.WebhookTimeoutSeconds),
+		ResyncPeriod:          s.WebhookResyncPeriod,
+	}
+}
+
+// readCABundle returns the func reading CA bundle file, the file is read every time
+// so that the rotated CA is used.
+func readCABundle(s *ServerRunOptions) func() []byte {
+	return func() []byte {
+		if s.CABundleFile == "" {
+			return nil
+		}
+		data, err := ioutil.ReadFile(s.CABundleFile)
 		if err != nil {
-			return nil, fmt.Errorf("Could not read CA certificate: %v", err)
+			klog.Errorf("Read CA bundle %v failed: %v", s.CABundleFile, err)
+			return nil
 		}
-		certPool.AppendCertsFromPEM(file)
-		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
-		tlsConfig.ClientCAs = certPool
+		return data
 	}
+}
 
-	return tlsConfig, nil
+func getTLSConfig(s *ServerRunOptions, store *cert.Store) *tls.Config {
+	tlsConfig := &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		// Avoid fallback on insecure SSL protocols
+		MinVersion: tls.VersionTLS10,
+	}
+	return store.TLSConfig(tlsConfig, s.TlsCA != "")
 }
 
 // NewSideCarConfig initializes the config of side car container
-func NewSideCarConfig(s *ServerRunOptions) *webhook.SideCarConfig {
-	cpu := resource.MustParse(s.CPU)
-	memory := resource.MustParse(s.Memory)
-	return &webhook.SideCarConfig{
-		Image:    s.Image,
-		CPU:      cpu,
-		Memory:   memory,
-		GrpcPort: s.GrpcPort,
-		HttpPort: s.HttpPort,
+func NewSideCarConfig(s *ServerRunOptions) (*webhook.SideCarConfig, error) {
+	cpu, err := resource.ParseQuantity(s.CPU)
+	if err != nil {
+		return nil, fmt.Errorf("invalid side car cpu %q: %v", s.CPU, err)
 	}
+	memory, err := resource.ParseQuantity(s.Memory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid side car memory %q: %v", s.Memory, err)
+	}
+	var ephemeralStorage, cpuLimit, memoryLimit, ephemeralStorageLimit resource.Quantity
+	for _, q := range []struct {
+		name     string
+		value    string
+		quantity *resource.Quantity
+	}{
+		{"ephemeral storage", s.EphemeralStorage, &ephemeralStorage},
+		{"cpu limit", s.CPULimit, &cpuLimit},
+		{"memory limit", s.MemoryLimit, &memoryLimit},
+		{"ephemeral storage limit", s.EphemeralStorageLimit, &ephemeralStorageLimit},
+	} {
+		if q.value == "" {
+			continue
+		}
+		if *q.quantity, err = resource.ParseQuantity(q.value); err != nil {
+			return nil, fmt.Errorf("invalid side car %v %q: %v", q.name, q.value, err)
+		}
+	}
+	probes, errs := newSideCarProbes(s.SidecarProbes, field.NewPath("sidecar", "probes"))
+	if len(errs) != 0 {
+		return nil, fmt.Errorf("invalid side car probes: %v", errs.ToAggregate())
+	}
+	if errs := webhook.ValidateSideCarEnv(s.SidecarEnv, field.NewPath("sidecar", "env")); len(errs) != 0 {
+		return nil, fmt.Errorf("invalid side car env: %v", errs.ToAggregate())
+	}
+	return &webhook.SideCarConfig{
+		Image:                 s.Image,
+		CPU:                   cpu,
+		Memory:                memory,
+		EphemeralStorage:      ephemeralStorage,
+		CPULimit:              cpuLimit,
+		MemoryLimit:           memoryLimit,
+		EphemeralStorageLimit: ephemeralStorageLimit,
+		GrpcPort:              s.GrpcPort,
+		HttpPort:              s.HttpPort,
+		Probes:                probes,
+		Token: webhook.TokenConfig{
+			Audience:          s.SidecarTokenAudience,
+			ExpirationSeconds: int64(s.SidecarTokenExpiration / time.Second),
+		},
+		LogLevel:    s.SidecarLogLevel,
+		Args:        s.SidecarArgs,
+		AllowedArgs: s.SidecarAllowedArgs,
+		Env:         s.SidecarEnv,
+		// in OptIn mode the injection is enabled by the inject label or annotation
+		InjectionDisabled: s.SidecarInjectionPolicy == string(webhook.InjectionOptIn),
+	}, nil
 }
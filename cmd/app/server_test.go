@@ -0,0 +1,88 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: kind
+  cluster:
+    server: https://127.0.0.1:6443
+- name: tunnel
+  cluster:
+    server: https://127.0.0.1:16443
+contexts:
+- name: kind
+  context:
+    cluster: kind
+    user: admin
+- name: tunnel
+  context:
+    cluster: tunnel
+    user: admin
+current-context: kind
+users:
+- name: admin
+  user:
+    token: test
+`
+
+func TestBuildKubeConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := ioutil.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name    string
+		options *ServerRunOptions
+		host    string
+	}{
+		{
+			name:    "current context",
+			options: &ServerRunOptions{Kubeconfig: path},
+			host:    "https://127.0.0.1:6443",
+		},
+		{
+			name:    "context",
+			options: &ServerRunOptions{Kubeconfig: path, Context: "tunnel"},
+			host:    "https://127.0.0.1:16443",
+		},
+		{
+			name:    "master overrides kubeconfig",
+			options: &ServerRunOptions{Kubeconfig: path, Master: "https://10.0.0.1:443"},
+			host:    "https://10.0.0.1:443",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			c.options.KubeAPIQPS, c.options.KubeAPIBurst = 5, 10
+			config, err := buildKubeConfig(c.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Host != c.host {
+				t.Errorf("desired host %v, got %v", c.host, config.Host)
+			}
+			if config.QPS != 5 || config.Burst != 10 {
+				t.Errorf("desired qps 5 and burst 10, got %v and %v", config.QPS, config.Burst)
+			}
+		})
+	}
+}
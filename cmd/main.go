@@ -26,6 +26,23 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "lint" {
+		if err := app.RunLint(os.Args[2:], os.Stdout); err != nil {
+			if err != app.ErrLintFailed {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := app.RunRender(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	options := app.NewServerRunOptions()
 	klog.InitFlags(nil)
 	defer klog.Flush()
@@ -39,11 +56,23 @@ func main() {
 	klog.Infof("Version: %s", app.Version)
 
 	klog.Infof("starting webhook server.")
+	if err := options.Complete(pflag.CommandLine); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 	if err := options.Validate(); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
+	if options.Uninstall {
+		if err := app.Uninstall(options); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := app.Run(options); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
@@ -0,0 +1,205 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package v1alpha1 is the v1alpha1 version of the webhook configuration file
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// GroupName is the group of webhook configuration file
+	GroupName = "webhook.carrier.ocgi.dev"
+	// Kind is the kind of webhook configuration file
+	Kind = "WebhookConfiguration"
+)
+
+// SchemeGroupVersion is the group version of webhook configuration file
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+// WebhookConfiguration configures the webhook server.
+// Omitted fields keep the defaults of command line flags.
+type WebhookConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// Server configures the listener
+	Server ServerConfiguration `json:"server,omitempty"`
+	// TLS configures the serving certificates
+	TLS TLSConfiguration `json:"tls,omitempty"`
+	// SideCar configures the injected sdk server container
+	SideCar SideCarConfiguration `json:"sidecar,omitempty"`
+	// Defaults configures the values set by the mutating webhook
+	Defaults DefaultsConfiguration `json:"defaults,omitempty"`
+	// Audit configures the audit log of admission decisions
+	Audit AuditConfiguration `json:"audit,omitempty"`
+}
+
+// UnhandledKindPolicy decides the requests of kinds without handler
+type UnhandledKindPolicy string
+
+const (
+	// UnhandledKindAllow allows the requests unchanged
+	UnhandledKindAllow UnhandledKindPolicy = "Allow"
+	// UnhandledKindDeny denies the requests
+	UnhandledKindDeny UnhandledKindPolicy = "Deny"
+)
+
+// UnhandledKindPolicies are the supported policies
+var UnhandledKindPolicies = []UnhandledKindPolicy{UnhandledKindAllow, UnhandledKindDeny}
+
+// ServerConfiguration configures the listener
+type ServerConfiguration struct {
+	// Address of webhook listening
+	Address string `json:"address,omitempty"`
+	// Port of webhook listening
+	Port int32 `json:"port,omitempty"`
+	// UnhandledKindPolicy decides the requests of kinds without handler, Allow or Deny
+	UnhandledKindPolicy string `json:"unhandledKindPolicy,omitempty"`
+}
+
+// TLSConfiguration configures the serving certificates
+type TLSConfiguration struct {
+	// CertFile is the path to TLS certificate file
+	CertFile string `json:"certFile,omitempty"`
+	// KeyFile is the path to TLS key file
+	KeyFile string `json:"keyFile,omitempty"`
+	// CAFile is the path to the CA verifying clients
+	CAFile string `json:"caFile,omitempty"`
+	// ReloadInterval is the interval of checking the files for changes
+	ReloadInterval *metav1.Duration `json:"reloadInterval,omitempty"`
+	// SelfSigned generates and rotates the serving certificates by the webhook itself
+	SelfSigned *bool `json:"selfSigned,omitempty"`
+}
+
+// InjectionPolicy decides the side car injection of pods without the inject label or annotation
+type InjectionPolicy string
+
+const (
+	// InjectionOptOut injects the side car unless disabled by the inject label or annotation
+	InjectionOptOut InjectionPolicy = "OptOut"
+	// InjectionOptIn injects the side car only if enabled by the inject label or annotation
+	InjectionOptIn InjectionPolicy = "OptIn"
+)
+
+// InjectionPolicies are the supported policies
+var InjectionPolicies = []InjectionPolicy{InjectionOptOut, InjectionOptIn}
+
+// SideCarConfiguration configures the injected sdk server container
+type SideCarConfiguration struct {
+	// Image of side car
+	Image string `json:"image,omitempty"`
+	// CPU request of side car, e.g. 100m
+	CPU string `json:"cpu,omitempty"`
+	// Memory request of side car, e.g. 100Mi
+	Memory string `json:"memory,omitempty"`
+	// EphemeralStorage request of side car, e.g. 1Gi
+	EphemeralStorage string `json:"ephemeralStorage,omitempty"`
+	// Limits of side car, a limit not set is the request
+	Limits SideCarLimitsConfiguration `json:"limits,omitempty"`
+	// HTTPPort of side car
+	HTTPPort int32 `json:"httpPort,omitempty"`
+	// GRPCPort of side car
+	GRPCPort int32 `json:"grpcPort,omitempty"`
+	// LogLevel is the klog verbosity of side car, default is 5
+	LogLevel *int32 `json:"logLevel,omitempty"`
+	// Probes are the probes of side car on its http port
+	Probes SideCarProbesConfiguration `json:"probes,omitempty"`
+	// Token configures the projected service account token injected if the pod has none
+	Token SideCarTokenConfiguration `json:"token,omitempty"`
+	// Args are the extra args of side car
+	Args []string `json:"args,omitempty"`
+	// AllowedArgs are the flags allowed in the args annotation of namespaces and pods, e.g. feature-gates
+	AllowedArgs []string `json:"allowedArgs,omitempty"`
+	// Env is the extra env of side car, a literal value or the key of a ConfigMap or Secret
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// TemplateConfigMap is the namespace/name of the ConfigMap whose container template is merged into side car
+	TemplateConfigMap string `json:"templateConfigMap,omitempty"`
+	// InjectionPolicy decides the injection of pods without the inject annotation, OptOut or OptIn
+	InjectionPolicy string `json:"injectionPolicy,omitempty"`
+}
+
+// SideCarLimitsConfiguration configures the resource limits of side car
+type SideCarLimitsConfiguration struct {
+	// CPU limit of side car, e.g. 500m
+	CPU string `json:"cpu,omitempty"`
+	// Memory limit of side car, e.g. 200Mi
+	Memory string `json:"memory,omitempty"`
+	// EphemeralStorage limit of side car, e.g. 2Gi
+	EphemeralStorage string `json:"ephemeralStorage,omitempty"`
+}
+
+// SideCarTokenConfiguration configures the projected service account token of side car
+type SideCarTokenConfiguration struct {
+	// Audience of the token, the apiserver audience if empty
+	Audience string `json:"audience,omitempty"`
+	// Expiration of the token, at least 10m
+	Expiration *metav1.Duration `json:"expiration,omitempty"`
+}
+
+// SideCarProbesConfiguration configures the probes of side car
+type SideCarProbesConfiguration struct {
+	// Liveness probe is enabled by default
+	Liveness *ProbeConfiguration `json:"liveness,omitempty"`
+	// Readiness probe is enabled if set
+	Readiness *ProbeConfiguration `json:"readiness,omitempty"`
+	// Startup probe is enabled if set
+	Startup *ProbeConfiguration `json:"startup,omitempty"`
+}
+
+// ProbeConfiguration configures an HTTP probe of side car, the fields not set are the ones of
+// the default probe of /healthz
+type ProbeConfiguration struct {
+	// Enabled disables the probe if false
+	Enabled             *bool  `json:"enabled,omitempty"`
+	Path                string `json:"path,omitempty"`
+	InitialDelaySeconds *int32 `json:"initialDelaySeconds,omitempty"`
+	TimeoutSeconds      *int32 `json:"timeoutSeconds,omitempty"`
+	PeriodSeconds       *int32 `json:"periodSeconds,omitempty"`
+	SuccessThreshold    *int32 `json:"successThreshold,omitempty"`
+	FailureThreshold    *int32 `json:"failureThreshold,omitempty"`
+}
+
+// DefaultsConfiguration configures the values set by the mutating webhook
+type DefaultsConfiguration struct {
+	// ServiceAccountName is the service account of game server pods, created if not exists
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// Scheduling is the default scheduling strategy
+	Scheduling string `json:"scheduling,omitempty"`
+	// PortPolicy is the default port policy of ports without host port
+	PortPolicy string `json:"portPolicy,omitempty"`
+	// RevisionHistoryLimit is the revision history limit of Squad
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// MaxSurge is the default max surge of Squad rolling update
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+	// MaxUnavailable is the default max unavailable of Squad rolling update
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// AuditConfiguration configures the audit log of admission decisions
+type AuditConfiguration struct {
+	// Path of the audit log file, "-" for stdout, disabled if empty
+	Path string `json:"path,omitempty"`
+	// MaxSizeMB is the size in megabytes rotating the file, 0 disables rotation
+	MaxSizeMB *int32 `json:"maxSizeMB,omitempty"`
+	// MaxBackups is the number of rotated files to keep
+	MaxBackups *int32 `json:"maxBackups,omitempty"`
+	// SampleRate is the fraction of allowed requests recorded, denied requests are always recorded
+	SampleRate *float64 `json:"sampleRate,omitempty"`
+	// MaxPatchBytes caps the patch recorded, 0 records the whole patch
+	MaxPatchBytes *int32 `json:"maxPatchBytes,omitempty"`
+}
@@ -0,0 +1,256 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	carrierv1alpha1 "github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
+)
+
+var (
+	supportedSchedulings = []string{
+		string(carrierv1alpha1.MostAllocated),
+		string(carrierv1alpha1.LeastAllocated),
+		string(carrierv1alpha1.Default),
+	}
+	supportedPortPolicies = []string{
+		string(carrierv1alpha1.Static),
+		string(carrierv1alpha1.Dynamic),
+		string(carrierv1alpha1.LoadBalancer),
+	}
+)
+
+// ValidateWebhookConfiguration validates the whole configuration, all the errors are returned.
+func ValidateWebhookConfiguration(c *WebhookConfiguration) field.ErrorList {
+	var errs field.ErrorList
+	if c.APIVersion != "" && c.APIVersion != SchemeGroupVersion.String() {
+		errs = append(errs, field.NotSupported(field.NewPath("apiVersion"), c.APIVersion,
+			[]string{SchemeGroupVersion.String()}))
+	}
+	if c.Kind != "" && c.Kind != Kind {
+		errs = append(errs, field.NotSupported(field.NewPath("kind"), c.Kind, []string{Kind}))
+	}
+	errs = append(errs, validateServer(&c.Server, field.NewPath("server"))...)
+	errs = append(errs, validateTLS(&c.TLS, field.NewPath("tls"))...)
+	errs = append(errs, validateSideCar(&c.SideCar, field.NewPath("sidecar"))...)
+	errs = append(errs, validateDefaults(&c.Defaults, field.NewPath("defaults"))...)
+	return append(errs, validateAudit(&c.Audit, field.NewPath("audit"))...)
+}
+
+func validateServer(c *ServerConfiguration, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if net.ParseIP(c.Address).To4() == nil {
+		errs = append(errs, field.Invalid(fldPath.Child("address"), c.Address, "must be a valid IPv4 address"))
+	}
+	var supported []string
+	for _, p := range UnhandledKindPolicies {
+		supported = append(supported, string(p))
+	}
+	if c.UnhandledKindPolicy != "" && !contains(supported, c.UnhandledKindPolicy) {
+		errs = append(errs, field.NotSupported(fldPath.Child("unhandledKindPolicy"), c.UnhandledKindPolicy, supported))
+	}
+	return append(errs, validatePort(c.Port, fldPath.Child("port"))...)
+}
+
+func validateTLS(c *TLSConfiguration, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		errs = append(errs, field.Required(fldPath.Child("certFile"), "certFile and keyFile must be set together"))
+	}
+	if c.SelfSigned != nil && *c.SelfSigned && (c.CertFile != "" || c.KeyFile != "" || c.CAFile != "") {
+		errs = append(errs, field.Forbidden(fldPath.Child("selfSigned"),
+			"can not be used with certFile, keyFile and caFile"))
+	}
+	if c.ReloadInterval != nil && c.ReloadInterval.Duration <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("reloadInterval"), c.ReloadInterval.Duration.String(),
+			"must be positive"))
+	}
+	return errs
+}
+
+func validateSideCar(c *SideCarConfiguration, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if c.Image == "" {
+		errs = append(errs, field.Required(fldPath.Child("image"), "image of side car is required"))
+	}
+	errs = append(errs, validateQuantity(c.CPU, fldPath.Child("cpu"))...)
+	errs = append(errs, validateQuantity(c.Memory, fldPath.Child("memory"))...)
+	errs = append(errs, validateLimit(c.CPU, c.Limits.CPU, fldPath.Child("limits", "cpu"))...)
+	errs = append(errs, validateLimit(c.Memory, c.Limits.Memory, fldPath.Child("limits", "memory"))...)
+	if c.EphemeralStorage != "" {
+		errs = append(errs, validateQuantity(c.EphemeralStorage, fldPath.Child("ephemeralStorage"))...)
+	}
+	errs = append(errs, validateLimit(c.EphemeralStorage, c.Limits.EphemeralStorage,
+		fldPath.Child("limits", "ephemeralStorage"))...)
+	errs = append(errs, validatePort(c.HTTPPort, fldPath.Child("httpPort"))...)
+	errs = append(errs, validatePort(c.GRPCPort, fldPath.Child("grpcPort"))...)
+	if c.HTTPPort == c.GRPCPort {
+		errs = append(errs, field.Duplicate(fldPath.Child("grpcPort"), c.GRPCPort))
+	}
+	errs = append(errs, validateNonNegative(c.LogLevel, fldPath.Child("logLevel"))...)
+	for i, arg := range c.Args {
+		if !strings.HasPrefix(arg, "--") {
+			errs = append(errs, field.Invalid(fldPath.Child("args").Index(i), arg, "must be a flag like --name=value"))
+		}
+	}
+	for i, name := range c.AllowedArgs {
+		switch {
+		case name == "" || strings.HasPrefix(name, "-") || strings.Contains(name, "="):
+			errs = append(errs, field.Invalid(fldPath.Child("allowedArgs").Index(i), name,
+				"must be a flag name without dashes, e.g. feature-gates"))
+		case name == "grpc-port" || name == "http-port" || name == "v":
+			errs = append(errs, field.Forbidden(fldPath.Child("allowedArgs").Index(i),
+				fmt.Sprintf("%v is set by the webhook", name)))
+		}
+	}
+	if c.TemplateConfigMap != "" {
+		errs = append(errs, validateNamespacedName(c.TemplateConfigMap, fldPath.Child("templateConfigMap"))...)
+	}
+	var supported []string
+	for _, p := range InjectionPolicies {
+		supported = append(supported, string(p))
+	}
+	if c.InjectionPolicy != "" && !contains(supported, c.InjectionPolicy) {
+		errs = append(errs, field.NotSupported(fldPath.Child("injectionPolicy"), c.InjectionPolicy, supported))
+	}
+	if c.Token.Expiration != nil && c.Token.Expiration.Duration < 10*time.Minute {
+		errs = append(errs, field.Invalid(fldPath.Child("token", "expiration"), c.Token.Expiration.Duration.String(),
+			"must be at least 10m"))
+	}
+	// the probes are merged into the default ones and validated by the server options
+	return errs
+}
+
+// validateNamespacedName validates a namespace/name reference
+func validateNamespacedName(value string, fldPath *field.Path) field.ErrorList {
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 {
+		return field.ErrorList{field.Invalid(fldPath, value, "must be namespace/name")}
+	}
+	var errs field.ErrorList
+	for _, msg := range apivalidation.ValidateNamespaceName(parts[0], false) {
+		errs = append(errs, field.Invalid(fldPath, value, msg))
+	}
+	for _, msg := range apivalidation.NameIsDNSSubdomain(parts[1], false) {
+		errs = append(errs, field.Invalid(fldPath, value, msg))
+	}
+	return errs
+}
+
+func validateDefaults(c *DefaultsConfiguration, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if c.ServiceAccountName != "" {
+		for _, msg := range apivalidation.NameIsDNSSubdomain(c.ServiceAccountName, false) {
+			errs = append(errs, field.Invalid(fldPath.Child("serviceAccountName"), c.ServiceAccountName, msg))
+		}
+	}
+	if c.Scheduling != "" && !contains(supportedSchedulings, c.Scheduling) {
+		errs = append(errs, field.NotSupported(fldPath.Child("scheduling"), c.Scheduling, supportedSchedulings))
+	}
+	if c.PortPolicy != "" && !contains(supportedPortPolicies, c.PortPolicy) {
+		errs = append(errs, field.NotSupported(fldPath.Child("portPolicy"), c.PortPolicy, supportedPortPolicies))
+	}
+	errs = append(errs, validateNonNegative(c.RevisionHistoryLimit, fldPath.Child("revisionHistoryLimit"))...)
+	errs = append(errs, validateIntOrPercent(c.MaxSurge, fldPath.Child("maxSurge"))...)
+	return append(errs, validateIntOrPercent(c.MaxUnavailable, fldPath.Child("maxUnavailable"))...)
+}
+
+func validateAudit(c *AuditConfiguration, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	errs = append(errs, validateNonNegative(c.MaxSizeMB, fldPath.Child("maxSizeMB"))...)
+	errs = append(errs, validateNonNegative(c.MaxBackups, fldPath.Child("maxBackups"))...)
+	errs = append(errs, validateNonNegative(c.MaxPatchBytes, fldPath.Child("maxPatchBytes"))...)
+	if c.SampleRate != nil && (*c.SampleRate < 0 || *c.SampleRate > 1) {
+		errs = append(errs, field.Invalid(fldPath.Child("sampleRate"), *c.SampleRate, "must be between 0 and 1"))
+	}
+	return errs
+}
+
+func validateNonNegative(value *int32, fldPath *field.Path) field.ErrorList {
+	if value != nil && *value < 0 {
+		return field.ErrorList{field.Invalid(fldPath, *value, "must be greater than or equal to 0")}
+	}
+	return nil
+}
+
+func validatePort(port int32, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for _, msg := range validation.IsValidPortNum(int(port)) {
+		errs = append(errs, field.Invalid(fldPath, port, msg))
+	}
+	return errs
+}
+
+func validateQuantity(value string, fldPath *field.Path) field.ErrorList {
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return field.ErrorList{field.Invalid(fldPath, value, err.Error())}
+	}
+	if quantity.Sign() < 0 {
+		return field.ErrorList{field.Invalid(fldPath, value, "must be greater than or equal to 0")}
+	}
+	return nil
+}
+
+// validateLimit validates limit if set, it must not be lower than request
+func validateLimit(request, limit string, fldPath *field.Path) field.ErrorList {
+	if limit == "" {
+		return nil
+	}
+	if errs := validateQuantity(limit, fldPath); len(errs) != 0 {
+		return errs
+	}
+	l := resource.MustParse(limit)
+	if r, err := resource.ParseQuantity(request); err == nil && l.Cmp(r) < 0 {
+		return field.ErrorList{field.Invalid(fldPath, limit,
+			fmt.Sprintf("must be greater than or equal to the request %v", request))}
+	}
+	return nil
+}
+
+func validateIntOrPercent(value *intstr.IntOrString, fldPath *field.Path) field.ErrorList {
+	if value == nil {
+		return nil
+	}
+	if value.Type == intstr.String {
+		if msgs := validation.IsValidPercent(value.StrVal); len(msgs) != 0 {
+			return field.ErrorList{field.Invalid(fldPath, value.StrVal, strings.Join(msgs, "; "))}
+		}
+		return nil
+	}
+	if value.IntVal < 0 {
+		return field.ErrorList{field.Invalid(fldPath, value.IntVal, "must be greater than or equal to 0")}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
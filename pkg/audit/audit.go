@@ -0,0 +1,182 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit writes one JSON record per admission decision.
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+)
+
+// StdoutPath writes the records to stdout instead of a file
+const StdoutPath = "-"
+
+// Options configures the audit logger
+type Options struct {
+	// Path of the audit log file, StdoutPath for stdout
+	Path string
+	// MaxSize is the size in bytes rotating the file, 0 disables rotation
+	MaxSize int64
+	// MaxBackups is the number of rotated files to keep
+	MaxBackups int
+	// SampleRate is the fraction of allowed requests recorded, denied requests are always recorded
+	SampleRate float64
+	// MaxPatchBytes caps the patch recorded, 0 records the whole patch
+	MaxPatchBytes int
+}
+
+// Record is the audit record of one AdmissionReview
+type Record struct {
+	Time       time.Time `json:"time"`
+	Webhook    string    `json:"webhook"`
+	UID        string    `json:"uid"`
+	APIVersion string    `json:"apiVersion"`
+	Kind       string    `json:"kind"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Operation  string    `json:"operation"`
+	User       string    `json:"user"`
+	Groups     []string  `json:"groups,omitempty"`
+	DryRun     bool      `json:"dryRun,omitempty"`
+	Allowed    bool      `json:"allowed"`
+	Code       int32     `json:"code,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	// FieldErrors are the causes of a denial
+	FieldErrors []FieldError `json:"fieldErrors,omitempty"`
+	Warnings    []string     `json:"warnings,omitempty"`
+	// AuditAnnotations describe what the webhook changed or why it denied
+	AuditAnnotations map[string]string `json:"auditAnnotations,omitempty"`
+	// Patch is the exact JSON patch, omitted if larger than MaxPatchBytes
+	Patch json.RawMessage `json:"patch,omitempty"`
+	// TruncatedPatch is the beginning of a patch larger than MaxPatchBytes
+	TruncatedPatch string `json:"truncatedPatch,omitempty"`
+	PatchBytes     int    `json:"patchBytes,omitempty"`
+	LatencyMillis  int64  `json:"latencyMillis"`
+}
+
+// FieldError is an invalid field of a denied request
+type FieldError struct {
+	Type    string `json:"type"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Logger writes the audit records, a nil Logger records nothing.
+type Logger struct {
+	mu      sync.Mutex
+	out     io.Writer
+	closer  io.Closer
+	options Options
+	sample  func() float64
+}
+
+// NewLogger creates the logger writing to options.Path
+func NewLogger(options Options) (*Logger, error) {
+	if options.Path == StdoutPath {
+		return NewLoggerForWriter(os.Stdout, options), nil
+	}
+	w, err := NewFileWriter(options.Path, options.MaxSize, options.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	l := NewLoggerForWriter(w, options)
+	l.closer = w
+	return l, nil
+}
+
+// NewLoggerForWriter creates the logger writing to out
+func NewLoggerForWriter(out io.Writer, options Options) *Logger {
+	return &Logger{out: out, options: options, sample: rand.Float64}
+}
+
+// Log records the decision of req, it is sampled if allowed.
+func (l *Logger) Log(webhook string, req *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse,
+	start time.Time) {
+	if l == nil || req == nil || resp == nil {
+		return
+	}
+	if resp.Allowed && l.options.SampleRate < 1 && l.sample() >= l.options.SampleRate {
+		return
+	}
+	data, err := json.Marshal(l.newRecord(webhook, req, resp, start))
+	if err != nil {
+		klog.Errorf("Can't encode audit record of %v: %v", req.UID, err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.out.Write(append(data, '\n')); err != nil {
+		klog.Errorf("Can't write audit record of %v: %v", req.UID, err)
+	}
+}
+
+// Close closes the audit log file
+func (l *Logger) Close() error {
+	if l == nil || l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+func (l *Logger) newRecord(webhook string, req *admissionv1.AdmissionRequest,
+	resp *admissionv1.AdmissionResponse, start time.Time) *Record {
+	r := &Record{
+		Time:             start.UTC(),
+		Webhook:          webhook,
+		UID:              string(req.UID),
+		APIVersion:       schema.GroupVersion{Group: req.Kind.Group, Version: req.Kind.Version}.String(),
+		Kind:             req.Kind.Kind,
+		Namespace:        req.Namespace,
+		Name:             req.Name,
+		Operation:        string(req.Operation),
+		User:             req.UserInfo.Username,
+		Groups:           req.UserInfo.Groups,
+		DryRun:           req.DryRun != nil && *req.DryRun,
+		Allowed:          resp.Allowed,
+		Warnings:         resp.Warnings,
+		AuditAnnotations: resp.AuditAnnotations,
+		LatencyMillis:    time.Since(start).Milliseconds(),
+	}
+	if resp.Result != nil {
+		r.Code = resp.Result.Code
+		r.Message = resp.Result.Message
+		if resp.Result.Details != nil {
+			for _, cause := range resp.Result.Details.Causes {
+				r.FieldErrors = append(r.FieldErrors, FieldError{
+					Type:    string(cause.Type),
+					Field:   cause.Field,
+					Message: cause.Message,
+				})
+			}
+		}
+	}
+	if len(resp.Patch) != 0 {
+		r.PatchBytes = len(resp.Patch)
+		if l.options.MaxPatchBytes > 0 && len(resp.Patch) > l.options.MaxPatchBytes {
+			r.TruncatedPatch = string(resp.Patch[:l.options.MaxPatchBytes])
+		} else {
+			r.Patch = resp.Patch
+		}
+	}
+	return r
+}
@@ -0,0 +1,197 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testRequest() *admissionv1.AdmissionRequest {
+	return &admissionv1.AdmissionRequest{
+		UID:       "uid",
+		Kind:      metav1.GroupVersionKind{Group: "carrier.ocgi.dev", Version: "v1alpha1", Kind: "Squad"},
+		Namespace: "default",
+		Name:      "squad",
+		Operation: admissionv1.Create,
+		UserInfo:  authenticationv1.UserInfo{Username: "alice", Groups: []string{"dev"}},
+	}
+}
+
+func decodeRecords(t *testing.T, data []byte) []Record {
+	var records []Record
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("decode %q: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestLog(t *testing.T) {
+	patch := []byte(`[{"op":"add","path":"/spec/replicas","value":1}]`)
+	denied := &admissionv1.AdmissionResponse{
+		Result: &metav1.Status{
+			Code:    400,
+			Message: "invalid",
+			Details: &metav1.StatusDetails{Causes: []metav1.StatusCause{
+				{Type: metav1.CauseTypeFieldValueRequired, Field: "spec.template", Message: "Required value"},
+			}},
+		},
+	}
+	for _, c := range []struct {
+		name    string
+		options Options
+		resp    *admissionv1.AdmissionResponse
+		sample  float64
+		desired int
+		check   func(t *testing.T, r Record)
+	}{
+		{
+			name:    "allowed with patch",
+			options: Options{SampleRate: 1},
+			resp:    &admissionv1.AdmissionResponse{Allowed: true, Patch: patch},
+			desired: 1,
+			check: func(t *testing.T, r Record) {
+				if !bytes.Equal(r.Patch, patch) || r.PatchBytes != len(patch) || r.User != "alice" ||
+					r.APIVersion != "carrier.ocgi.dev/v1alpha1" || r.Kind != "Squad" || r.Webhook != "mutate" {
+					t.Errorf("unexpected record %+v", r)
+				}
+			},
+		},
+		{
+			name:    "patch truncated",
+			options: Options{SampleRate: 1, MaxPatchBytes: 10},
+			resp:    &admissionv1.AdmissionResponse{Allowed: true, Patch: patch},
+			desired: 1,
+			check: func(t *testing.T, r Record) {
+				if len(r.Patch) != 0 || r.TruncatedPatch != string(patch[:10]) || r.PatchBytes != len(patch) {
+					t.Errorf("unexpected record %+v", r)
+				}
+			},
+		},
+		{
+			name:    "allowed not sampled",
+			options: Options{SampleRate: 0.5},
+			resp:    &admissionv1.AdmissionResponse{Allowed: true},
+			sample:  0.9,
+			desired: 0,
+		},
+		{
+			name:    "denied always recorded",
+			options: Options{SampleRate: 0},
+			resp:    denied,
+			sample:  0.9,
+			desired: 1,
+			check: func(t *testing.T, r Record) {
+				if r.Allowed || r.Code != 400 || len(r.FieldErrors) != 1 || r.FieldErrors[0].Field != "spec.template" {
+					t.Errorf("unexpected record %+v", r)
+				}
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var out bytes.Buffer
+			l := NewLoggerForWriter(&out, c.options)
+			l.sample = func() float64 { return c.sample }
+			l.Log("mutate", testRequest(), c.resp, time.Now())
+			records := decodeRecords(t, out.Bytes())
+			if len(records) != c.desired {
+				t.Fatalf("desired %v records, got %v", c.desired, len(records))
+			}
+			if c.check != nil {
+				c.check(t, records[0])
+			}
+		})
+	}
+}
+
+func TestFileWriterRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.log")
+	w, err := NewFileWriter(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for file, desired := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != desired {
+			t.Errorf("%v: desired %q, got %q", file, desired, data)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("only 2 backups should be kept, got %v", err)
+	}
+}
+
+func TestFileWriterRotateFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	w, err := NewFileWriter(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	// a non-empty directory at the backup path fails the rename
+	if err := os.MkdirAll(filepath.Join(path+".1", "busy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatalf("write should continue after a failed rotation, got %v", err)
+		}
+	}
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("third\n")); err != nil {
+		t.Fatal(err)
+	}
+	for file, desired := range map[string]string{path: "third\n", path + ".1": "first\nsecond\n"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != desired {
+			t.Errorf("%v: desired %q, got %q", file, desired, data)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+// FileWriter appends to a file and rotates it when it exceeds the max size.
+// The rotated files are named <path>.1 to <path>.<maxBackups>, the newest first.
+type FileWriter struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	// file is nil if closed or if it could not be reopened by the last rotation
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+// NewFileWriter opens path for appending, a maxSize of 0 disables rotation.
+func NewFileWriter(path string, maxSize int64, maxBackups int) (*FileWriter, error) {
+	w := &FileWriter{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write writes p to the file, rotating it first if p does not fit. If the rotation fails, the error
+// is logged and p is appended to the current file, the rotation is retried by the next write.
+func (w *FileWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, fmt.Errorf("audit log %v is closed", w.path)
+	}
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			klog.Errorf("Rotate audit log %v failed: %v", w.path, err)
+			if w.file == nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the file
+func (w *FileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *FileWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file, w.size = file, info.Size()
+	return nil
+}
+
+// rotate moves the file to the backups and reopens it. The file is reopened even if the move fails,
+// so a transient error does not stop the writer, w.file is nil only if the file can not be reopened.
+func (w *FileWriter) rotate() error {
+	closeErr := w.file.Close()
+	w.file = nil
+	moveErr := w.moveToBackups()
+	if err := w.open(); err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return moveErr
+}
+
+// moveToBackups shifts the backups and renames the file to the first one, or removes it without backups
+func (w *FileWriter) moveToBackups() error {
+	if w.maxBackups == 0 {
+		if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	for i := w.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(w.backup(i), w.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(w.path, w.backup(1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (w *FileWriter) backup(i int) string {
+	return fmt.Sprintf("%v.%d", w.path, i)
+}
@@ -0,0 +1,299 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cert
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"fmt"
+	"sync"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+const (
+	// CACertKey is the key of CA bundle in the secret
+	CACertKey = "ca.crt"
+	// CAKeyKey is the key of CA private key in the secret
+	CAKeyKey = "ca.key"
+	// caValidity is the validity of the self-signed CA
+	caValidity = 10 * 365 * 24 * time.Hour
+)
+
+// SelfSignedOptions describes where the self-signed certificates are stored and used
+type SelfSignedOptions struct {
+	// SecretName is the name of secret storing the certificates
+	SecretName string
+	// SecretNamespace is the namespace of secret storing the certificates
+	SecretNamespace string
+	// ServiceName is the name of webhook service
+	ServiceName string
+	// ServiceNamespace is the namespace of webhook service
+	ServiceNamespace string
+	// MutatingWebhookName is the MutatingWebhookConfiguration to inject CA bundle
+	MutatingWebhookName string
+	// ValidatingWebhookName is the ValidatingWebhookConfiguration to inject CA bundle
+	ValidatingWebhookName string
+	// Validity is the validity of serving certificate
+	Validity time.Duration
+	// RotateBefore rotates the certificates when they expire within it
+	RotateBefore time.Duration
+	// CheckInterval is the interval of checking the secret
+	CheckInterval time.Duration
+}
+
+// SelfSigner generates a CA and a serving certificate, stores them in a Secret
+// shared by all replicas, serves the certificate by Store and injects the CA
+// into the webhook configurations.
+type SelfSigner struct {
+	SelfSignedOptions
+	client kubernetes.Interface
+	store  *Store
+
+	mu       sync.RWMutex
+	caBundle []byte
+	certPEM  []byte
+}
+
+// NewSelfSigner creates a SelfSigner
+func NewSelfSigner(client kubernetes.Interface, store *Store, options SelfSignedOptions) *SelfSigner {
+	return &SelfSigner{
+		SelfSignedOptions: options,
+		client:            client,
+		store:             store,
+	}
+}
+
+// CABundle returns the PEM encoded CA bundle of the serving certificate
+func (s *SelfSigner) CABundle() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.caBundle
+}
+
+// Run ensures the certificates periodically until stop is closed.
+func (s *SelfSigner) Run(stop <-chan struct{}) {
+	wait.Until(func() {
+		if err := s.Ensure(); err != nil {
+			klog.Errorf("Ensure self-signed certificates failed: %v", err)
+		}
+	}, s.CheckInterval, stop)
+}
+
+// Ensure creates or rotates the certificates in the secret, loads them into the store
+// and injects the CA bundle into webhook configurations.
+func (s *SelfSigner) Ensure() error {
+	secret, err := s.ensureSecret()
+	if err != nil {
+		return err
+	}
+	certPEM := secret.Data[corev1.TLSCertKey]
+	if !bytes.Equal(certPEM, s.certPEM) {
+		if err := s.store.SetKeyPair(certPEM, secret.Data[corev1.TLSPrivateKeyKey]); err != nil {
+			return err
+		}
+		s.certPEM = certPEM
+		notAfter, _ := s.store.NotAfter()
+		klog.Infof("Loaded serving certificate from secret %v/%v, expires at %v",
+			s.SecretNamespace, s.SecretName, notAfter)
+	}
+	s.mu.Lock()
+	s.caBundle = secret.Data[CACertKey]
+	s.mu.Unlock()
+	return s.injectCABundle()
+}
+
+// ensureSecret returns the secret with valid certificates, only one replica wins
+// the creation or rotation, the others use what it stored.
+func (s *SelfSigner) ensureSecret() (*corev1.Secret, error) {
+	secrets := s.client.CoreV1().Secrets(s.SecretNamespace)
+	secret, err := secrets.Get(context.TODO(), s.SecretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		data, _, err := s.rotate(nil)
+		if err != nil {
+			return nil, err
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.SecretName,
+				Namespace: s.SecretNamespace,
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: data,
+		}
+		created, err := secrets.Create(context.TODO(), secret, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			klog.Infof("Secret %v/%v is created by another replica", s.SecretNamespace, s.SecretName)
+			return secrets.Get(context.TODO(), s.SecretName, metav1.GetOptions{})
+		}
+		if err == nil {
+			klog.Infof("Created self-signed certificates in secret %v/%v", s.SecretNamespace, s.SecretName)
+		}
+		return created, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, rotated, err := s.rotate(secret.Data)
+	if err != nil || !rotated {
+		return secret, err
+	}
+	secretCopy := secret.DeepCopy()
+	secretCopy.Data = data
+	updated, err := secrets.Update(context.TODO(), secretCopy, metav1.UpdateOptions{})
+	if errors.IsConflict(err) {
+		klog.Infof("Secret %v/%v is rotated by another replica", s.SecretNamespace, s.SecretName)
+		return secrets.Get(context.TODO(), s.SecretName, metav1.GetOptions{})
+	}
+	if err == nil {
+		klog.Infof("Rotated self-signed certificates in secret %v/%v", s.SecretNamespace, s.SecretName)
+	}
+	return updated, err
+}
+
+// rotate returns new secret data if the certificates are missing or going to expire.
+// When the CA is rotated, the old CA is kept in the bundle until it expires, so that
+// the replicas still serving the old certificate are trusted.
+func (s *SelfSigner) rotate(data map[string][]byte) (map[string][]byte, bool, error) {
+	deadline := time.Now().Add(s.RotateBefore)
+	caCertPEM, caKeyPEM := data[CACertKey], data[CAKeyKey]
+	cas, err := ParseCertificates(caCertPEM)
+	rotateCA := err != nil || len(caKeyPEM) == 0 || deadline.After(cas[0].NotAfter)
+
+	rotateServing := rotateCA
+	if !rotateServing {
+		certs, err := ParseCertificates(data[corev1.TLSCertKey])
+		rotateServing = err != nil || deadline.After(certs[0].NotAfter) ||
+			!coversDNSNames(certs[0].DNSNames, ServiceDNSNames(s.ServiceName, s.ServiceNamespace))
+	}
+	if !rotateServing {
+		return data, false, nil
+	}
+
+	if rotateCA {
+		newCACertPEM, newCAKeyPEM, err := GenerateCA(fmt.Sprintf("%s-ca", s.ServiceName), caValidity)
+		if err != nil {
+			return nil, false, err
+		}
+		bundle := newCACertPEM
+		for _, ca := range cas {
+			if time.Now().Before(ca.NotAfter) {
+				bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})...)
+			}
+		}
+		caCertPEM, caKeyPEM = bundle, newCAKeyPEM
+	}
+	certPEM, keyPEM, err := GenerateServingCert(caCertPEM, caKeyPEM,
+		ServiceDNSNames(s.ServiceName, s.ServiceNamespace), s.Validity)
+	if err != nil {
+		return nil, false, err
+	}
+	return map[string][]byte{
+		CACertKey:               caCertPEM,
+		CAKeyKey:                caKeyPEM,
+		corev1.TLSCertKey:       certPEM,
+		corev1.TLSPrivateKeyKey: keyPEM,
+	}, true, nil
+}
+
+// injectCABundle sets the CA bundle of webhooks calling our service
+func (s *SelfSigner) injectCABundle() error {
+	caBundle := s.CABundle()
+	var errs []error
+	if s.MutatingWebhookName != "" {
+		client := s.client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+		config, err := client.Get(context.TODO(), s.MutatingWebhookName, metav1.GetOptions{})
+		if err == nil {
+			configCopy := config.DeepCopy()
+			var configs []*admissionregistrationv1.WebhookClientConfig
+			for i := range configCopy.Webhooks {
+				configs = append(configs, &configCopy.Webhooks[i].ClientConfig)
+			}
+			if s.setCABundles(configs, caBundle) {
+				_, err = client.Update(context.TODO(), configCopy, metav1.UpdateOptions{})
+			}
+		}
+		errs = append(errs, ignoreNotFound("MutatingWebhookConfiguration", s.MutatingWebhookName, err))
+	}
+	if s.ValidatingWebhookName != "" {
+		client := s.client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+		config, err := client.Get(context.TODO(), s.ValidatingWebhookName, metav1.GetOptions{})
+		if err == nil {
+			configCopy := config.DeepCopy()
+			var configs []*admissionregistrationv1.WebhookClientConfig
+			for i := range configCopy.Webhooks {
+				configs = append(configs, &configCopy.Webhooks[i].ClientConfig)
+			}
+			if s.setCABundles(configs, caBundle) {
+				_, err = client.Update(context.TODO(), configCopy, metav1.UpdateOptions{})
+			}
+		}
+		errs = append(errs, ignoreNotFound("ValidatingWebhookConfiguration", s.ValidatingWebhookName, err))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// ignoreNotFound returns nil if the webhook configuration is not found, it is injected once created
+func ignoreNotFound(kind, name string, err error) error {
+	if errors.IsNotFound(err) {
+		klog.V(4).Infof("%v %v not found", kind, name)
+		return nil
+	}
+	return err
+}
+
+// setCABundles sets the CA bundle of configs calling our service, returns true if any changed.
+func (s *SelfSigner) setCABundles(configs []*admissionregistrationv1.WebhookClientConfig, caBundle []byte) bool {
+	changed := false
+	for _, config := range configs {
+		changed = s.setCABundle(config, caBundle) || changed
+	}
+	return changed
+}
+
+// setCABundle sets the CA bundle if the webhook calls our service, returns true if changed.
+func (s *SelfSigner) setCABundle(config *admissionregistrationv1.WebhookClientConfig, caBundle []byte) bool {
+	if config.Service == nil || config.Service.Name != s.ServiceName ||
+		config.Service.Namespace != s.ServiceNamespace {
+		return false
+	}
+	if bytes.Equal(config.CABundle, caBundle) {
+		return false
+	}
+	config.CABundle = caBundle
+	return true
+}
+
+func coversDNSNames(names, desired []string) bool {
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[name] = struct{}{}
+	}
+	for _, name := range desired {
+		if _, ok := set[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
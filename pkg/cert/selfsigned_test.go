@@ -0,0 +1,173 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testSelfSignedOptions() SelfSignedOptions {
+	return SelfSignedOptions{
+		SecretName:            "carrier-webhook-certs",
+		SecretNamespace:       "kube-system",
+		ServiceName:           "carrier-webhook-service",
+		ServiceNamespace:      "kube-system",
+		MutatingWebhookName:   "carrier-mutator",
+		ValidatingWebhookName: "carrier-validator",
+		Validity:              365 * 24 * time.Hour,
+		RotateBefore:          30 * 24 * time.Hour,
+		CheckInterval:         time.Hour,
+	}
+}
+
+func testWebhookConfigs() (*admissionregistrationv1.MutatingWebhookConfiguration,
+	*admissionregistrationv1.ValidatingWebhookConfiguration) {
+	ours := admissionregistrationv1.WebhookClientConfig{
+		Service: &admissionregistrationv1.ServiceReference{Name: "carrier-webhook-service", Namespace: "kube-system"},
+	}
+	others := admissionregistrationv1.WebhookClientConfig{
+		Service: &admissionregistrationv1.ServiceReference{Name: "other", Namespace: "kube-system"},
+	}
+	return &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "carrier-mutator"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{Name: "carrier-webhook.ocgi.dev", ClientConfig: ours},
+			{Name: "other.ocgi.dev", ClientConfig: others},
+		},
+	}, &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "carrier-validator"},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{Name: "carrier-validator.ocgi.dev", ClientConfig: ours},
+		},
+	}
+}
+
+func getSecret(t *testing.T, client kubernetes.Interface) *corev1.Secret {
+	secret, err := client.CoreV1().Secrets("kube-system").Get(context.TODO(), "carrier-webhook-certs", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func TestSelfSignerBootstrap(t *testing.T) {
+	mutating, validating := testWebhookConfigs()
+	client := fake.NewSimpleClientset(mutating, validating)
+
+	first, second := NewStore(), NewStore()
+	if err := NewSelfSigner(client, first, testSelfSignedOptions()).Ensure(); err != nil {
+		t.Fatal(err)
+	}
+	// another replica uses the certificate created by the first one
+	if err := NewSelfSigner(client, second, testSelfSignedOptions()).Ensure(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Certificate().Certificate[0], second.Certificate().Certificate[0]) {
+		t.Errorf("replicas should serve the same certificate")
+	}
+
+	secret := getSecret(t, client)
+	caBundle := secret.Data[CACertKey]
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caBundle)
+	if _, err := first.Certificate().Leaf.Verify(x509.VerifyOptions{
+		DNSName: "carrier-webhook-service.kube-system.svc",
+		Roots:   roots,
+	}); err != nil {
+		t.Errorf("serving certificate should be trusted by CA: %v", err)
+	}
+
+	gotMutating, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().
+		Get(context.TODO(), "carrier-mutator", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotMutating.Webhooks[0].ClientConfig.CABundle, caBundle) {
+		t.Errorf("CA bundle should be injected into mutating webhook")
+	}
+	if len(gotMutating.Webhooks[1].ClientConfig.CABundle) != 0 {
+		t.Errorf("CA bundle should not be injected into webhook of other service")
+	}
+	gotValidating, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().
+		Get(context.TODO(), "carrier-validator", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotValidating.Webhooks[0].ClientConfig.CABundle, caBundle) {
+		t.Errorf("CA bundle should be injected into validating webhook")
+	}
+}
+
+func TestSelfSignerRotate(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	options := testSelfSignedOptions()
+	store := NewStore()
+	signer := NewSelfSigner(client, store, options)
+	if err := signer.Ensure(); err != nil {
+		t.Fatal(err)
+	}
+	created := getSecret(t, client)
+
+	// nothing to rotate
+	if err := signer.Ensure(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(getSecret(t, client).Data[corev1.TLSCertKey], created.Data[corev1.TLSCertKey]) {
+		t.Fatalf("certificate should not be rotated")
+	}
+
+	// serving certificate expires soon, CA is kept
+	options.RotateBefore = options.Validity + time.Hour
+	signer = NewSelfSigner(client, store, options)
+	data, rotated, err := signer.rotate(created.Data)
+	if err != nil || !rotated {
+		t.Fatalf("serving certificate should be rotated, err: %v", err)
+	}
+	if !bytes.Equal(data[CACertKey], created.Data[CACertKey]) {
+		t.Errorf("CA should be kept when only serving certificate expires")
+	}
+
+	// CA expires soon, old CA is kept in bundle
+	options.RotateBefore = caValidity + time.Hour
+	signer = NewSelfSigner(client, store, options)
+	if err := signer.Ensure(); err != nil {
+		t.Fatal(err)
+	}
+	rotatedSecret := getSecret(t, client)
+	cas, err := ParseCertificates(rotatedSecret.Data[CACertKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cas) != 2 {
+		t.Fatalf("desired 2 CAs in bundle, got %v", len(cas))
+	}
+	createdCerts, err := ParseCertificates(created.Data[corev1.TLSCertKey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Certificate().Leaf.SerialNumber.Cmp(createdCerts[0].SerialNumber) == 0 {
+		t.Errorf("store should serve rotated certificate")
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Store holds the serving key pair and the client CA pool of the webhook.
+// Both of them can be replaced at runtime without restarting the server.
+type Store struct {
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// NewStore creates an empty Store
+func NewStore() *Store {
+	return &Store{}
+}
+
+// SetKeyPair parses and replaces the serving key pair. If the pair is
+// invalid, the last good one is kept and an error is returned.
+func (s *Store) SetKeyPair(certPEM, keyPEM []byte) error {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("parse key pair failed: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parse certificate failed: %v", err)
+	}
+	pair.Leaf = leaf
+	s.mu.Lock()
+	s.cert = &pair
+	s.mu.Unlock()
+	return nil
+}
+
+// SetClientCA parses and replaces the CA pool used to verify clients.
+// If no certificate can be parsed, the last good pool is kept and an error is returned.
+func (s *Store) SetClientCA(caPEM []byte) error {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("no valid CA certificate found")
+	}
+	s.mu.Lock()
+	s.clientCAs = pool
+	s.mu.Unlock()
+	return nil
+}
+
+// Certificate returns the current serving key pair, nil if not loaded.
+func (s *Store) Certificate() *tls.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert
+}
+
+// ClientCAs returns the current client CA pool, nil if not loaded.
+func (s *Store) ClientCAs() *x509.CertPool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.clientCAs
+}
+
+// NotAfter returns the expiry of the current serving certificate.
+func (s *Store) NotAfter() (time.Time, bool) {
+	cert := s.Certificate()
+	if cert == nil || cert.Leaf == nil {
+		return time.Time{}, false
+	}
+	return cert.Leaf.NotAfter, true
+}
+
+// Check returns an error if the serving certificate is not loaded, expired or not yet valid.
+func (s *Store) Check() error {
+	cert := s.Certificate()
+	if cert == nil || cert.Leaf == nil {
+		return fmt.Errorf("serving certificate is not loaded")
+	}
+	now := time.Now()
+	if now.After(cert.Leaf.NotAfter) {
+		return fmt.Errorf("serving certificate expired at %v", cert.Leaf.NotAfter)
+	}
+	if now.Before(cert.Leaf.NotBefore) {
+		return fmt.Errorf("serving certificate is not valid before %v", cert.Leaf.NotBefore)
+	}
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (s *Store) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := s.Certificate()
+	if cert == nil {
+		return nil, fmt.Errorf("serving certificate is not loaded")
+	}
+	return cert, nil
+}
+
+// TLSConfig returns a copy of base which always serves the current key pair.
+// If verifyClient is true, clients are verified against the current client CA pool.
+func (s *Store) TLSConfig(base *tls.Config, verifyClient bool) *tls.Config {
+	config := base.Clone()
+	config.GetCertificate = s.GetCertificate
+	if !verifyClient {
+		return config
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		clientConfig := config.Clone()
+		clientConfig.GetConfigForClient = nil
+		clientConfig.ClientCAs = s.ClientCAs()
+		return clientConfig, nil
+	}
+	return config
+}
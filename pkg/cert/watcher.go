@@ -0,0 +1,114 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cert
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+// FileWatcher reloads the key pair and client CA into a Store when the files change.
+// Files are polled instead of watched by inotify, because secrets mounted in pods
+// are replaced by swapping symlinks.
+type FileWatcher struct {
+	store    *Store
+	certFile string
+	keyFile  string
+	caFile   string
+	interval time.Duration
+
+	certPEM []byte
+	keyPEM  []byte
+	caPEM   []byte
+}
+
+// NewFileWatcher creates a watcher for the key pair and the optional CA file
+func NewFileWatcher(store *Store, certFile, keyFile, caFile string, interval time.Duration) *FileWatcher {
+	return &FileWatcher{
+		store:    store,
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		interval: interval,
+	}
+}
+
+// Load loads the files into the store, it should be called before serving.
+func (w *FileWatcher) Load() error {
+	if err := w.loadKeyPair(); err != nil {
+		return err
+	}
+	if w.caFile == "" {
+		return nil
+	}
+	return w.loadCA()
+}
+
+// Run polls the files until stop is closed.
+func (w *FileWatcher) Run(stop <-chan struct{}) {
+	wait.Until(func() {
+		if err := w.loadKeyPair(); err != nil {
+			klog.Errorf("Reload key pair failed, keep using the last one: %v", err)
+		}
+		if w.caFile == "" {
+			return
+		}
+		if err := w.loadCA(); err != nil {
+			klog.Errorf("Reload CA failed, keep using the last one: %v", err)
+		}
+	}, w.interval, stop)
+}
+
+func (w *FileWatcher) loadKeyPair() error {
+	certPEM, err := ioutil.ReadFile(w.certFile)
+	if err != nil {
+		return fmt.Errorf("read certificate %v failed: %v", w.certFile, err)
+	}
+	keyPEM, err := ioutil.ReadFile(w.keyFile)
+	if err != nil {
+		return fmt.Errorf("read key %v failed: %v", w.keyFile, err)
+	}
+	if bytes.Equal(certPEM, w.certPEM) && bytes.Equal(keyPEM, w.keyPEM) {
+		return nil
+	}
+	if err := w.store.SetKeyPair(certPEM, keyPEM); err != nil {
+		return err
+	}
+	w.certPEM, w.keyPEM = certPEM, keyPEM
+	notAfter, _ := w.store.NotAfter()
+	klog.Infof("Loaded serving certificate %v, expires at %v", w.certFile, notAfter)
+	return nil
+}
+
+func (w *FileWatcher) loadCA() error {
+	caPEM, err := ioutil.ReadFile(w.caFile)
+	if err != nil {
+		return fmt.Errorf("read CA %v failed: %v", w.caFile, err)
+	}
+	if bytes.Equal(caPEM, w.caPEM) {
+		return nil
+	}
+	if err := w.store.SetClientCA(caPEM); err != nil {
+		return fmt.Errorf("load CA %v failed: %v", w.caFile, err)
+	}
+	w.caPEM = caPEM
+	klog.Infof("Loaded client CA %v", w.caFile)
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestKeyPair(t *testing.T, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileWatcherReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"),
+		filepath.Join(dir, "ca.pem")
+
+	firstExpiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	certPEM, keyPEM := newTestKeyPair(t, firstExpiry)
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	writeFile(t, caFile, certPEM)
+
+	store := NewStore()
+	w := NewFileWatcher(store, certFile, keyFile, caFile, time.Second)
+	if err := w.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if notAfter, ok := store.NotAfter(); !ok || !notAfter.Equal(firstExpiry) {
+		t.Fatalf("desired expiry %v, got %v", firstExpiry, notAfter)
+	}
+	if store.ClientCAs() == nil {
+		t.Fatalf("client CA should be loaded")
+	}
+
+	// a broken pair keeps the last good one
+	writeFile(t, certFile, []byte("broken"))
+	if err := w.loadKeyPair(); err == nil {
+		t.Fatalf("broken certificate should fail")
+	}
+	if notAfter, _ := store.NotAfter(); !notAfter.Equal(firstExpiry) {
+		t.Fatalf("last good certificate should be kept, got expiry %v", notAfter)
+	}
+
+	secondExpiry := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	certPEM, keyPEM = newTestKeyPair(t, secondExpiry)
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	if err := w.loadKeyPair(); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := store.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Leaf.NotAfter.Equal(secondExpiry) {
+		t.Fatalf("desired expiry %v, got %v", secondExpiry, cert.Leaf.NotAfter)
+	}
+}
+
+func TestStoreNotLoaded(t *testing.T) {
+	store := NewStore()
+	if _, err := store.GetCertificate(nil); err == nil {
+		t.Errorf("empty store should not return certificate")
+	}
+	if _, ok := store.NotAfter(); ok {
+		t.Errorf("empty store should not have expiry")
+	}
+	if err := store.Check(); err == nil {
+		t.Errorf("empty store should not pass the check")
+	}
+}
+
+func TestStoreCheck(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		notAfter time.Time
+		healthy  bool
+	}{
+		{name: "valid", notAfter: time.Now().Add(time.Hour), healthy: true},
+		{name: "expired", notAfter: time.Now().Add(-time.Minute), healthy: false},
+	} {
+		store := NewStore()
+		certPEM, keyPEM := newTestKeyPair(t, c.notAfter)
+		if err := store.SetKeyPair(certPEM, keyPEM); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Check(); (err == nil) != c.healthy {
+			t.Errorf("%v: desired healthy %v, got %v", c.name, c.healthy, err)
+		}
+	}
+}
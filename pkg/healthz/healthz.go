@@ -0,0 +1,88 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package healthz serves named health checks, e.g. /livez and /readyz.
+package healthz
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+)
+
+// Checker is a named health check
+type Checker struct {
+	// Name is shown in the verbose output and serves the check alone at <path>/<name>
+	Name string
+	// Check returns nil if healthy
+	Check func(r *http.Request) error
+}
+
+// NamedCheck returns a Checker of check
+func NamedCheck(name string, check func(r *http.Request) error) Checker {
+	return Checker{Name: name, Check: check}
+}
+
+// PingCheck always succeeds, it tells the server is serving
+var PingCheck = NamedCheck("ping", func(*http.Request) error { return nil })
+
+// InstallHandler serves all the checks at path, and each one at path/<name>.
+// Pass ?verbose to list the result of every check, and ?exclude=<name> to skip checks.
+func InstallHandler(mux *http.ServeMux, path string, checks ...Checker) {
+	mux.Handle(path, handleRootHealth(path, checks))
+	for _, check := range checks {
+		mux.Handle(path+"/"+check.Name, handleRootHealth(path+"/"+check.Name, []Checker{check}))
+	}
+}
+
+func handleRootHealth(path string, checks []Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		excluded := sets.NewString()
+		for _, names := range r.URL.Query()["exclude"] {
+			excluded.Insert(strings.Split(names, ",")...)
+		}
+		var out bytes.Buffer
+		var failed []string
+		for _, check := range checks {
+			if excluded.Has(check.Name) {
+				fmt.Fprintf(&out, "[+]%v excluded: ok\n", check.Name)
+				continue
+			}
+			if err := check.Check(r); err != nil {
+				fmt.Fprintf(&out, "[-]%v failed: %v\n", check.Name, err)
+				failed = append(failed, check.Name)
+				continue
+			}
+			fmt.Fprintf(&out, "[+]%v ok\n", check.Name)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if len(failed) != 0 {
+			klog.V(2).Infof("%v check failed: %v", path, strings.Join(failed, ","))
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%v%v check failed\n", out.String(), path)
+			return
+		}
+		if _, verbose := r.URL.Query()["verbose"]; verbose {
+			fmt.Fprintf(w, "%v%v check passed\n", out.String(), path)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}
+}
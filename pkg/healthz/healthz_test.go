@@ -0,0 +1,66 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package healthz
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInstallHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	InstallHandler(mux, "/readyz", PingCheck, NamedCheck("broken", func(*http.Request) error {
+		return fmt.Errorf("not synced")
+	}))
+	for _, c := range []struct {
+		url  string
+		code int
+		body string
+	}{
+		{
+			url:  "/readyz",
+			code: http.StatusInternalServerError,
+			body: "[+]ping ok\n[-]broken failed: not synced\n/readyz check failed\n",
+		},
+		{
+			url:  "/readyz?exclude=broken",
+			code: http.StatusOK,
+			body: "ok",
+		},
+		{
+			url:  "/readyz?verbose&exclude=broken",
+			code: http.StatusOK,
+			body: "[+]ping ok\n[+]broken excluded: ok\n/readyz check passed\n",
+		},
+		{
+			url:  "/readyz/ping",
+			code: http.StatusOK,
+			body: "ok",
+		},
+		{
+			url:  "/readyz/broken",
+			code: http.StatusInternalServerError,
+			body: "[-]broken failed: not synced\n/readyz/broken check failed\n",
+		},
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.url, nil))
+		if w.Code != c.code || w.Body.String() != c.body {
+			t.Errorf("%v: desired %v %q, got %v %q", c.url, c.code, c.body, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lint validates carrier manifests offline with the functions of the webhook.
+package lint
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
+
+	"github.com/ocgi/carrier-webhook/pkg/manifest"
+	"github.com/ocgi/carrier-webhook/pkg/webhook"
+)
+
+// Result is the lint result of one document
+type Result struct {
+	File      string `json:"file"`
+	Index     int    `json:"index"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Operation is CREATE, or UPDATE if linted against an old version
+	Operation string   `json:"operation"`
+	Errors    []Error  `json:"errors,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+	doc       *manifest.Document
+}
+
+// Error is a field error of the document
+type Error struct {
+	Field   string `json:"field"`
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// String describes the error like the apiserver
+func (e Error) String() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%v: %v", e.Field, e.Message)
+}
+
+// Failed returns true if the document has errors
+func (r *Result) Failed() bool {
+	return len(r.Errors) != 0
+}
+
+// Lint defaults and validates docs like the webhook does on creation.
+// If a document has an old version in olds, it is validated as an update.
+// The side car ports of config, overridden by the namespaces by name, are validated against the game server ports.
+func Lint(docs, olds []*manifest.Document, config *webhook.SideCarConfig, defaults *webhook.Defaults,
+	namespaces map[string]*corev1.Namespace) []*Result {
+	oldByKey := map[string]*manifest.Document{}
+	for _, old := range olds {
+		oldByKey[old.Key()] = old
+	}
+	var results []*Result
+	for _, doc := range docs {
+		results = append(results, lintDocument(doc, oldByKey[doc.Key()], config, defaults, namespaces[doc.Namespace]))
+	}
+	return results
+}
+
+func lintDocument(doc, old *manifest.Document, config *webhook.SideCarConfig, defaults *webhook.Defaults,
+	namespace *corev1.Namespace) *Result {
+	r := &Result{
+		File:      doc.File,
+		Index:     doc.Index,
+		Kind:      doc.Kind,
+		Namespace: doc.Namespace,
+		Name:      doc.Name,
+		Operation: "CREATE",
+		doc:       doc,
+	}
+	if old != nil {
+		r.Operation = "UPDATE"
+	}
+	if doc.DecodeErr != nil {
+		r.Errors = append(r.Errors, Error{Type: "DecodeError", Message: doc.DecodeErr.Error()})
+		return r
+	}
+	if old != nil && old.DecodeErr != nil {
+		r.Errors = append(r.Errors, Error{Type: "DecodeError",
+			Message: fmt.Sprintf("old version %v: %v", old, old.DecodeErr)})
+		return r
+	}
+	// the side car ports are validated first like the webhook, the update validations modify the objects
+	var errs, portErrs field.ErrorList
+	var oldSpec *v1alpha1.GameServerSpec
+	if old != nil {
+		oldSpec = gameServerSpec(old.Object)
+	}
+	templatePath := field.NewPath("spec", "template", "spec")
+	switch obj := doc.Object.(type) {
+	case *v1alpha1.GameServer:
+		// the template of a GameServer owned by a GameServerSet is validated with its owner
+		if metav1.GetControllerOf(obj) == nil {
+			portErrs = webhook.ValidateSideCarPorts(&obj.Spec, oldSpec, config, namespace, field.NewPath("spec"))
+		}
+		errs, r.Warnings = lintGameServer(obj, old, config, defaults)
+	case *v1alpha1.GameServerSet:
+		portErrs = webhook.ValidateSideCarPorts(&obj.Spec.Template.Spec, oldSpec, config, namespace, templatePath)
+		errs, r.Warnings = lintGameServerSet(obj, old, config, defaults)
+	case *v1alpha1.Squad:
+		portErrs = webhook.ValidateSideCarPorts(&obj.Spec.Template.Spec, oldSpec, config, namespace, templatePath)
+		errs, r.Warnings = lintSquad(obj, old, config, defaults)
+	}
+	errs = append(portErrs, errs...)
+	for _, err := range errs {
+		r.Errors = append(r.Errors, Error{Field: err.Field, Type: string(err.Type), Message: err.ErrorBody()})
+	}
+	return r
+}
+
+// gameServerSpec returns the spec of the game servers of obj, nil if obj is not a carrier kind
+func gameServerSpec(obj interface{}) *v1alpha1.GameServerSpec {
+	switch obj := obj.(type) {
+	case *v1alpha1.GameServer:
+		return &obj.Spec
+	case *v1alpha1.GameServerSet:
+		return &obj.Spec.Template.Spec
+	case *v1alpha1.Squad:
+		return &obj.Spec.Template.Spec
+	}
+	return nil
+}
+
+// lintGameServer runs the webhook on a GameServer, the old version was defaulted on creation
+func lintGameServer(gs *v1alpha1.GameServer, old *manifest.Document, config *webhook.SideCarConfig,
+	d *webhook.Defaults) (field.ErrorList, []string) {
+	if old == nil {
+		gs, _ = webhook.EnsureDefaultForGameServer(gs, d)
+		result := webhook.ValidateGameServer(gs, config)
+		return result.Errors, result.Warnings
+	}
+	oldGS, _ := webhook.EnsureDefaultForGameServer(old.Object.(*v1alpha1.GameServer), d)
+	result := webhook.ValidateGameServerUpdate(oldGS, gs.DeepCopy(), config)
+	return result.Errors, result.Warnings
+}
+
+// lintGameServerSet runs the webhook on a GameServerSet, the old version was defaulted on creation
+func lintGameServerSet(gsSet *v1alpha1.GameServerSet, old *manifest.Document, config *webhook.SideCarConfig,
+	d *webhook.Defaults) (field.ErrorList, []string) {
+	if old == nil {
+		gsSet, _ = webhook.EnsureDefaultsForGameServerSet(gsSet, d)
+		result := webhook.ValidateGameServerSet(gsSet, config)
+		return result.Errors, result.Warnings
+	}
+	oldGSSet, _ := webhook.EnsureDefaultsForGameServerSet(old.Object.(*v1alpha1.GameServerSet), d)
+	result := webhook.ValidateGameServerSetUpdate(oldGSSet, gsSet.DeepCopy(), config)
+	return result.Errors, result.Warnings
+}
+
+// lintSquad runs the webhook on a Squad, the old version was defaulted on creation
+func lintSquad(squad *v1alpha1.Squad, old *manifest.Document, config *webhook.SideCarConfig,
+	d *webhook.Defaults) (field.ErrorList, []string) {
+	if old == nil {
+		squad, _ = webhook.EnsureDefaultsForSquad(squad, d)
+		result := webhook.ValidateSquad(squad, config)
+		return result.Errors, result.Warnings
+	}
+	oldSquad, _ := webhook.EnsureDefaultsForSquad(old.Object.(*v1alpha1.Squad), d)
+	squad, _ = webhook.CopyDefaultsForSquad(oldSquad, squad, d)
+	result := webhook.ValidateSquadUpdate(oldSquad, squad.DeepCopy(), config)
+	return result.Errors, result.Warnings
+}
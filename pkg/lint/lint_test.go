@@ -0,0 +1,171 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lint
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/ocgi/carrier-webhook/pkg/manifest"
+	"github.com/ocgi/carrier-webhook/pkg/webhook"
+)
+
+const squads = `# comment only
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: skipped
+---
+apiVersion: carrier.ocgi.dev/v1alpha1
+kind: Squad
+metadata:
+  name: valid
+spec:
+  template:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: server
+            image: game:v1
+---
+apiVersion: carrier.ocgi.dev/v1alpha1
+kind: Squad
+metadata:
+  name: invalid
+spec:
+  template:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: game
+            image: game:v1
+`
+
+const gameServers = `apiVersion: carrier.ocgi.dev/v1alpha1
+kind: GameServer
+metadata:
+  name: conflict
+spec:
+  ports:
+  - name: game
+    containerPortRange:
+      minPort: 9000
+      maxPort: 9100
+  template:
+    spec:
+      containers:
+      - name: server
+        image: game:v1
+`
+
+func testConfig() *webhook.SideCarConfig {
+	return &webhook.SideCarConfig{
+		Image:    "sdkserver:v1",
+		CPU:      resource.MustParse("100m"),
+		Memory:   resource.MustParse("100Mi"),
+		HttpPort: 9021,
+		GrpcPort: 9020,
+		LogLevel: 5,
+	}
+}
+
+func TestLint(t *testing.T) {
+	docs, err := manifest.LoadDocuments("squads.yaml", strings.NewReader(squads), manifest.CarrierKinds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := Lint(docs, nil, testConfig(), webhook.NewDefaults(), nil)
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %v", len(results))
+	}
+	if results[0].Failed() {
+		t.Errorf("valid squad failed: %v", results[0].Errors)
+	}
+	if !results[1].Failed() || results[1].Errors[0].Field != "spec.template.spec.containers" ||
+		results[1].Operation != "CREATE" {
+		t.Errorf("expected containers error on create, got %+v", results[1])
+	}
+
+	old := strings.Replace(squads, "game:v1", "game:v0", -1)
+	old = strings.Replace(old, "name: server", "name: server\n            workingDir: /old", 1)
+	olds, err := manifest.LoadDocuments("old.yaml", strings.NewReader(old), manifest.CarrierKinds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results = Lint(docs, olds, testConfig(), webhook.NewDefaults(), nil)
+	if results[0].Operation != "UPDATE" || !results[0].Failed() ||
+		results[0].Errors[0].Field != "spec.template.spec" {
+		t.Errorf("expected forbidden template update, got %+v", results[0])
+	}
+	if results[1].Operation != "UPDATE" || results[1].Failed() {
+		t.Errorf("image update should be allowed, got %+v", results[1])
+	}
+}
+
+func TestLintPortConflict(t *testing.T) {
+	docs, err := manifest.LoadDocuments("gameservers.yaml", strings.NewReader(gameServers), manifest.CarrierKinds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := Lint(docs, nil, testConfig(), webhook.NewDefaults(), nil)
+	if len(results) != 1 || len(results[0].Errors) != 2 ||
+		results[0].Errors[0].Field != "spec.ports[0].containerPortRange" {
+		t.Fatalf("expected conflicts of grpc and http ports, got %+v", results)
+	}
+	if msg := results[0].Errors[1].Message; !strings.Contains(msg, "conflicts with side car http port 9021") {
+		t.Errorf("unexpected message %v", msg)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	docs, err := manifest.LoadDocuments("squads.yaml", strings.NewReader(squads), manifest.CarrierKinds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := Lint(docs, nil, testConfig(), webhook.NewDefaults(), nil)
+
+	buf := &bytes.Buffer{}
+	if err := WriteReport(buf, OutputText, results); err != nil {
+		t.Fatal(err)
+	}
+	expected := "squads.yaml[3] Squad/invalid: error: spec.template.spec.containers: "
+	if !strings.Contains(buf.String(), expected) || !strings.Contains(buf.String(), "2 documents linted, 1 failed") {
+		t.Errorf("unexpected text output:\n%v", buf)
+	}
+
+	buf.Reset()
+	if err := WriteReport(buf, OutputJUnit, results); err != nil {
+		t.Fatal(err)
+	}
+	suites := junitTestSuites{}
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatal(err)
+	}
+	if len(suites.Suites) != 1 || suites.Suites[0].Tests != 2 || suites.Suites[0].Failures != 1 ||
+		suites.Suites[0].Cases[1].Failure == nil {
+		t.Errorf("unexpected junit output:\n%v", buf)
+	}
+
+	if err := WriteReport(buf, "yaml", results); err == nil {
+		t.Errorf("unsupported output should fail")
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lint
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Output formats of the report
+const (
+	OutputText  = "text"
+	OutputJSON  = "json"
+	OutputJUnit = "junit"
+)
+
+// Outputs are the supported output formats
+var Outputs = []string{OutputText, OutputJSON, OutputJUnit}
+
+// WriteReport writes the results in format
+func WriteReport(w io.Writer, format string, results []*Result) error {
+	switch format {
+	case OutputText:
+		return WriteText(w, results)
+	case OutputJSON:
+		return WriteJSON(w, results)
+	case OutputJUnit:
+		return WriteJUnit(w, results)
+	}
+	return fmt.Errorf("unsupported output %q, expect one of %v", format, Outputs)
+}
+
+// WriteText writes one line per error and warning, and a summary
+func WriteText(w io.Writer, results []*Result) error {
+	failed := 0
+	for _, r := range results {
+		if r.Failed() {
+			failed++
+		}
+		for _, err := range r.Errors {
+			fmt.Fprintf(w, "%v: error: %v\n", r.doc, err)
+		}
+		for _, warning := range r.Warnings {
+			fmt.Fprintf(w, "%v: warning: %v\n", r.doc, warning)
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d documents linted, %d failed\n", len(results), failed)
+	return err
+}
+
+// WriteJSON writes the results as a JSON array
+func WriteJSON(w io.Writer, results []*Result) error {
+	if results == nil {
+		results = []*Result{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes one test suite per file and one test case per document
+func WriteJUnit(w io.Writer, results []*Result) error {
+	suites := junitTestSuites{}
+	index := map[string]int{}
+	for _, r := range results {
+		i, ok := index[r.File]
+		if !ok {
+			i = len(suites.Suites)
+			index[r.File] = i
+			suites.Suites = append(suites.Suites, junitTestSuite{Name: r.File})
+		}
+		suite := &suites.Suites[i]
+		c := junitTestCase{
+			ClassName: r.File,
+			Name:      fmt.Sprintf("[%d] %v/%v", r.Index, r.Kind, r.Name),
+			SystemOut: strings.Join(r.Warnings, "\n"),
+		}
+		if r.Failed() {
+			var lines []string
+			for _, err := range r.Errors {
+				lines = append(lines, err.String())
+			}
+			c.Failure = &junitFailure{
+				Message: fmt.Sprintf("%d errors", len(r.Errors)),
+				Type:    r.Errors[0].Type,
+				Text:    strings.Join(lines, "\n"),
+			}
+			suite.Failures++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, c)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
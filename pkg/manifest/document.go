@@ -0,0 +1,176 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package manifest loads the objects of multi-document YAML and JSON files.
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
+)
+
+// Document is one YAML document of a manifest file
+type Document struct {
+	// File is the path of the manifest
+	File string
+	// Index is the index of the document in the file, starts from 0
+	Index int
+	// Kind of the object
+	Kind string
+	// Namespace and Name of the object
+	Namespace, Name string
+	// Object is created by Kinds and decoded, nil if DecodeErr is set
+	Object interface{}
+	// DecodeErr is the error of decoding the object
+	DecodeErr error
+}
+
+// Key identifies the object of the document
+func (d *Document) Key() string {
+	return d.Kind + "/" + d.Namespace + "/" + d.Name
+}
+
+// String describes where the document is, e.g. squad.yaml[1] Squad/test
+func (d *Document) String() string {
+	return fmt.Sprintf("%v[%d] %v/%v", d.File, d.Index, d.Kind, d.Name)
+}
+
+// Kinds creates the object of each kind to decode
+type Kinds map[schema.GroupVersionKind]func() interface{}
+
+// CarrierKinds are GameServer, GameServerSet and Squad
+var CarrierKinds = Kinds{
+	v1alpha1.SchemeGroupVersion.WithKind("GameServer"):    func() interface{} { return &v1alpha1.GameServer{} },
+	v1alpha1.SchemeGroupVersion.WithKind("GameServerSet"): func() interface{} { return &v1alpha1.GameServerSet{} },
+	v1alpha1.SchemeGroupVersion.WithKind("Squad"):         func() interface{} { return &v1alpha1.Squad{} },
+}
+
+// NamespaceKinds are Namespace, whose labels and annotations override the side car config
+var NamespaceKinds = Kinds{
+	corev1.SchemeGroupVersion.WithKind("Namespace"): func() interface{} { return &corev1.Namespace{} },
+}
+
+// PodKinds are the carrier kinds and Pod
+var PodKinds = Kinds{
+	corev1.SchemeGroupVersion.WithKind("Pod"): func() interface{} { return &corev1.Pod{} },
+}
+
+func init() {
+	for gvk, newObject := range CarrierKinds {
+		PodKinds[gvk] = newObject
+	}
+}
+
+// LoadFiles loads the documents of kinds in files, directories are walked recursively.
+// Documents of other kinds are skipped.
+func LoadFiles(paths []string, kinds Kinds) ([]*Document, error) {
+	var docs []*Document
+	for _, path := range paths {
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || (file != path && !isManifest(file)) {
+				return nil
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			fileDocs, err := LoadDocuments(file, f, kinds)
+			if err != nil {
+				return err
+			}
+			docs = append(docs, fileDocs...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// LoadDocuments loads the documents of kinds in a multi-document YAML or JSON stream.
+func LoadDocuments(file string, r io.Reader, kinds Kinds) ([]*Document, error) {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	var docs []*Document
+	for index := 0; ; index++ {
+		data, err := reader.Read()
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %v[%d] failed: %v", file, index, err)
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			index--
+			continue
+		}
+		doc, err := decodeDocument(data, kinds)
+		if err != nil {
+			return nil, fmt.Errorf("decode %v[%d] failed: %v", file, index, err)
+		}
+		if doc == nil {
+			continue
+		}
+		doc.File, doc.Index = file, index
+		docs = append(docs, doc)
+	}
+}
+
+// decodeDocument decodes the object, nil if the document is not of kinds.
+func decodeDocument(data []byte, kinds Kinds) (*Document, error) {
+	var meta struct {
+		metav1.TypeMeta   `json:",inline"`
+		metav1.ObjectMeta `json:"metadata,omitempty"`
+	}
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	newObject, ok := kinds[meta.GroupVersionKind()]
+	if !ok {
+		return nil, nil
+	}
+	doc := &Document{Kind: meta.Kind, Namespace: meta.Namespace, Name: meta.Name}
+	obj := newObject()
+	if err := yaml.UnmarshalStrict(data, obj); err != nil {
+		doc.DecodeErr = err
+		return doc, nil
+	}
+	doc.Object = obj
+	return doc, nil
+}
+
+func isManifest(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
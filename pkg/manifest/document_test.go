@@ -0,0 +1,125 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const squads = `# comment only
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: skipped
+---
+apiVersion: carrier.ocgi.dev/v1alpha1
+kind: Squad
+metadata:
+  name: valid
+spec:
+  template:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: server
+            image: game:v1
+---
+apiVersion: carrier.ocgi.dev/v1alpha1
+kind: Squad
+metadata:
+  name: invalid
+spec:
+  template:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: game
+            image: game:v1
+`
+
+const gameServers = `apiVersion: carrier.ocgi.dev/v1alpha1
+kind: GameServer
+metadata:
+  name: gs
+spec:
+  unknown: true
+`
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"squads.yaml":         squads,
+		"sub/gs.yml":          gameServers,
+		"sub/README.md":       "not a manifest",
+		"sub/deep/empty.json": "",
+	})
+	docs, err := LoadFiles([]string{dir}, CarrierKinds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, doc := range docs {
+		rel, _ := filepath.Rel(dir, doc.File)
+		doc.File = rel
+		got = append(got, doc.String())
+	}
+	expected := []string{"squads.yaml[2] Squad/valid", "squads.yaml[3] Squad/invalid", "sub/gs.yml[0] GameServer/gs"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if docs[2].DecodeErr == nil {
+		t.Errorf("unknown field should fail decoding")
+	}
+}
+
+func TestLoadDocumentsOfKinds(t *testing.T) {
+	pod := "apiVersion: v1\nkind: Pod\nmetadata:\n  name: pod\n"
+	for _, kinds := range []Kinds{CarrierKinds, PodKinds} {
+		docs, err := LoadDocuments("pod.yaml", strings.NewReader(squads+"---\n"+pod), kinds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, isPod := docs[len(docs)-1].Object.(*corev1.Pod)
+		if isPod != (len(kinds) == len(PodKinds)) {
+			t.Errorf("pod should be loaded only with PodKinds, got %v", docs)
+		}
+	}
+}
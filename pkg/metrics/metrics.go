@@ -0,0 +1,119 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+const (
+	namespace = "carrier_webhook"
+	// unknownReason is the denial reason when the response has no field error
+	unknownReason = "Unknown"
+)
+
+var (
+	// AdmissionRequests counts the admission requests
+	AdmissionRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "admission_requests_total",
+		Help:      "Number of admission requests.",
+	}, []string{"webhook", "kind", "operation", "namespace"})
+
+	// AdmissionLatency observes the latency of admission requests
+	AdmissionLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "admission_duration_seconds",
+		Help:      "Latency of admission requests in seconds.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"webhook", "kind", "operation", "namespace"})
+
+	// AdmissionDecisions counts the allowed and denied requests
+	AdmissionDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "admission_decisions_total",
+		Help:      "Number of admission decisions.",
+	}, []string{"webhook", "kind", "operation", "namespace", "allowed"})
+
+	// AdmissionDenials counts the denied requests by field error type
+	AdmissionDenials = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "admission_denials_total",
+		Help:      "Number of field errors of denied admission requests.",
+	}, []string{"webhook", "kind", "operation", "namespace", "reason"})
+
+	// PatchSize observes the size of JSON patches
+	PatchSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "patch_size_bytes",
+		Help:      "Size of JSON patches returned by the mutating webhook in bytes.",
+		Buckets:   prometheus.ExponentialBuckets(64, 2, 10),
+	}, []string{"kind", "operation", "namespace"})
+
+	// SidecarInjections counts the sidecars injected into pods
+	SidecarInjections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sidecar_injections_total",
+		Help:      "Number of sidecars injected into pods.",
+	}, []string{"namespace"})
+
+	// RBACFailures counts the failures of creating RBAC objects for sdk server
+	RBACFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rbac_failures_total",
+		Help:      "Number of failures of creating ServiceAccount, RoleBinding and ClusterRole for sdk server.",
+	}, []string{"action", "namespace"})
+)
+
+var registerOnce sync.Once
+
+// Register registers all the metrics into the default registry
+func Register() {
+	registerOnce.Do(func() {
+		prometheus.MustRegister(AdmissionRequests, AdmissionLatency, AdmissionDecisions, AdmissionDenials,
+			PatchSize, SidecarInjections, RBACFailures)
+	})
+}
+
+// ObserveAdmission records the request and response of webhook
+func ObserveAdmission(webhook string, req *admissionv1.AdmissionRequest,
+	resp *admissionv1.AdmissionResponse, start time.Time) {
+	if req == nil || resp == nil {
+		return
+	}
+	kind, operation := req.Kind.Kind, string(req.Operation)
+	AdmissionRequests.WithLabelValues(webhook, kind, operation, req.Namespace).Inc()
+	AdmissionLatency.WithLabelValues(webhook, kind, operation, req.Namespace).Observe(time.Since(start).Seconds())
+	AdmissionDecisions.WithLabelValues(webhook, kind, operation, req.Namespace,
+		strconv.FormatBool(resp.Allowed)).Inc()
+	if len(resp.Patch) != 0 {
+		PatchSize.WithLabelValues(kind, operation, req.Namespace).Observe(float64(len(resp.Patch)))
+	}
+	if resp.Allowed {
+		return
+	}
+	if resp.Result == nil || resp.Result.Details == nil || len(resp.Result.Details.Causes) == 0 {
+		AdmissionDenials.WithLabelValues(webhook, kind, operation, req.Namespace, unknownReason).Inc()
+		return
+	}
+	for _, cause := range resp.Result.Details.Causes {
+		AdmissionDenials.WithLabelValues(webhook, kind, operation, req.Namespace, string(cause.Type)).Inc()
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestObserveAdmission(t *testing.T) {
+	req := &admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Kind: "Squad"},
+		Namespace: "game",
+		Operation: admissionv1.Create,
+	}
+	denied := &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Details: &metav1.StatusDetails{
+				Causes: []metav1.StatusCause{
+					{Type: metav1.CauseTypeFieldValueInvalid},
+					{Type: metav1.CauseTypeFieldValueInvalid},
+					{Type: metav1.CauseTypeFieldValueNotSupported},
+				},
+			},
+		},
+	}
+	ObserveAdmission("validate", req, denied, time.Now())
+	ObserveAdmission("mutate", req, &admissionv1.AdmissionResponse{Allowed: true, Patch: []byte("[]")}, time.Now())
+
+	if v := testutil.ToFloat64(AdmissionRequests.WithLabelValues("validate", "Squad", "CREATE", "game")); v != 1 {
+		t.Errorf("desired 1 request, got %v", v)
+	}
+	if v := testutil.ToFloat64(AdmissionDecisions.WithLabelValues("validate", "Squad", "CREATE", "game", "false")); v != 1 {
+		t.Errorf("desired 1 denial, got %v", v)
+	}
+	if v := testutil.ToFloat64(AdmissionDenials.WithLabelValues("validate", "Squad", "CREATE", "game",
+		string(metav1.CauseTypeFieldValueInvalid))); v != 2 {
+		t.Errorf("desired 2 invalid field errors, got %v", v)
+	}
+	if v := testutil.ToFloat64(AdmissionDecisions.WithLabelValues("mutate", "Squad", "CREATE", "game", "true")); v != 1 {
+		t.Errorf("desired 1 allowed, got %v", v)
+	}
+	if n := testutil.CollectAndCount(AdmissionLatency); n != 2 {
+		t.Errorf("desired 2 latency series, got %v", n)
+	}
+	if !AdmissionLatency.DeleteLabelValues("validate", "Squad", "CREATE", "game") {
+		t.Errorf("latency should be observed by namespace")
+	}
+	if n := testutil.CollectAndCount(PatchSize); n != 1 {
+		t.Errorf("desired 1 patch size series, got %v", n)
+	}
+}
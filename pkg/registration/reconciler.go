@@ -0,0 +1,310 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registration
+
+import (
+	"context"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+
+	"github.com/ocgi/carrier-webhook/pkg/webhook"
+)
+
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "carrier-webhook"
+	// mutatingWebhookName is the name of webhook in MutatingWebhookConfiguration
+	mutatingWebhookName = "carrier-webhook.ocgi.dev"
+	// validatingWebhookName is the name of webhook in ValidatingWebhookConfiguration
+	validatingWebhookName = "carrier-validator.ocgi.dev"
+)
+
+// Options describes the webhook configurations to reconcile
+type Options struct {
+	// MutatingWebhookName is the name of MutatingWebhookConfiguration
+	MutatingWebhookName string
+	// ValidatingWebhookName is the name of ValidatingWebhookConfiguration
+	ValidatingWebhookName string
+	// ServiceName is the name of webhook service
+	ServiceName string
+	// ServiceNamespace is the namespace of webhook service
+	ServiceNamespace string
+	// ServicePort is the port of webhook service
+	ServicePort int32
+	// FailurePolicy is the failure policy of webhooks
+	FailurePolicy admissionregistrationv1.FailurePolicyType
+	// TimeoutSeconds is the timeout of calling webhooks
+	TimeoutSeconds int32
+	// ResyncPeriod is the interval of fixing drift
+	ResyncPeriod time.Duration
+	// MutatingRules are the resources sent to the mutating webhook
+	MutatingRules []admissionregistrationv1.RuleWithOperations
+	// ValidatingRules are the resources sent to the validating webhook
+	ValidatingRules []admissionregistrationv1.RuleWithOperations
+}
+
+// Reconciler creates and updates the webhook configurations of the running binary
+type Reconciler struct {
+	Options
+	client kubernetes.Interface
+	// caBundle returns the CA of serving certificate, the existing one
+	// is kept if it returns empty.
+	caBundle func() []byte
+}
+
+// NewReconciler creates a Reconciler
+func NewReconciler(client kubernetes.Interface, options Options, caBundle func() []byte) *Reconciler {
+	if caBundle == nil {
+		caBundle = func() []byte { return nil }
+	}
+	return &Reconciler{
+		Options:  options,
+		client:   client,
+		caBundle: caBundle,
+	}
+}
+
+// Run reconciles the webhook configurations periodically until stop is closed.
+func (r *Reconciler) Run(stop <-chan struct{}) {
+	wait.Until(func() {
+		if err := r.Reconcile(); err != nil {
+			klog.Errorf("Reconcile webhook configurations failed: %v", err)
+		}
+	}, r.ResyncPeriod, stop)
+}
+
+// Reconcile creates the webhook configurations or updates them if they drift.
+func (r *Reconciler) Reconcile() error {
+	return utilerrors.NewAggregate([]error{
+		r.reconcileMutating(),
+		r.reconcileValidating(),
+	})
+}
+
+// Delete deletes the webhook configurations
+func (r *Reconciler) Delete() error {
+	var errs []error
+	err := r.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Delete(context.TODO(),
+		r.MutatingWebhookName, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		errs = append(errs, err)
+	}
+	err = r.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Delete(context.TODO(),
+		r.ValidatingWebhookName, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		klog.Infof("Deleted webhook configurations %v and %v", r.MutatingWebhookName, r.ValidatingWebhookName)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// MutatingWebhookConfiguration builds the desired MutatingWebhookConfiguration
+func (r *Reconciler) MutatingWebhookConfiguration() *admissionregistrationv1.MutatingWebhookConfiguration {
+	// service accounts and role bindings are created unless the request is dry-run
+	sideEffects := admissionregistrationv1.SideEffectClassNoneOnDryRun
+	reinvocationPolicy := admissionregistrationv1.NeverReinvocationPolicy
+	return &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: r.objectMeta(r.MutatingWebhookName),
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{
+				Name:                    mutatingWebhookName,
+				ClientConfig:            r.clientConfig(webhook.MutatePath),
+				Rules:                   r.MutatingRules,
+				FailurePolicy:           r.failurePolicy(),
+				MatchPolicy:             matchPolicy(),
+				NamespaceSelector:       namespaceSelector(),
+				ObjectSelector:          &metav1.LabelSelector{},
+				SideEffects:             &sideEffects,
+				TimeoutSeconds:          r.timeoutSeconds(),
+				AdmissionReviewVersions: append([]string(nil), webhook.SupportedReviewVersions...),
+				ReinvocationPolicy:      &reinvocationPolicy,
+			},
+		},
+	}
+}
+
+// ValidatingWebhookConfiguration builds the desired ValidatingWebhookConfiguration
+func (r *Reconciler) ValidatingWebhookConfiguration() *admissionregistrationv1.ValidatingWebhookConfiguration {
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	return &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: r.objectMeta(r.ValidatingWebhookName),
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{
+				Name:                    validatingWebhookName,
+				ClientConfig:            r.clientConfig(webhook.ValidatePath),
+				Rules:                   r.ValidatingRules,
+				FailurePolicy:           r.failurePolicy(),
+				MatchPolicy:             matchPolicy(),
+				NamespaceSelector:       namespaceSelector(),
+				ObjectSelector:          &metav1.LabelSelector{},
+				SideEffects:             &sideEffects,
+				TimeoutSeconds:          r.timeoutSeconds(),
+				AdmissionReviewVersions: append([]string(nil), webhook.SupportedReviewVersions...),
+			},
+		},
+	}
+}
+
+func (r *Reconciler) reconcileMutating() error {
+	client := r.client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	desired := r.MutatingWebhookConfiguration()
+	existing, err := client.Get(context.TODO(), desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = client.Create(context.TODO(), desired, metav1.CreateOptions{})
+		if err == nil {
+			klog.Infof("Created MutatingWebhookConfiguration %v", desired.Name)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	var desiredConfigs, existingConfigs []*admissionregistrationv1.WebhookClientConfig
+	for i := range desired.Webhooks {
+		desiredConfigs = append(desiredConfigs, &desired.Webhooks[i].ClientConfig)
+	}
+	for i := range existing.Webhooks {
+		existingConfigs = append(existingConfigs, &existing.Webhooks[i].ClientConfig)
+	}
+	keepCABundles(desiredConfigs, existingConfigs)
+	if !needsUpdate(apiequality.Semantic.DeepEqual(existing.Webhooks, desired.Webhooks), existing) {
+		return nil
+	}
+	existingCopy := existing.DeepCopy()
+	existingCopy.Webhooks = desired.Webhooks
+	setManagedBy(existingCopy)
+	_, err = client.Update(context.TODO(), existingCopy, metav1.UpdateOptions{})
+	if err == nil {
+		klog.Infof("Updated drifted MutatingWebhookConfiguration %v", desired.Name)
+	}
+	return err
+}
+
+func (r *Reconciler) reconcileValidating() error {
+	client := r.client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	desired := r.ValidatingWebhookConfiguration()
+	existing, err := client.Get(context.TODO(), desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = client.Create(context.TODO(), desired, metav1.CreateOptions{})
+		if err == nil {
+			klog.Infof("Created ValidatingWebhookConfiguration %v", desired.Name)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	var desiredConfigs, existingConfigs []*admissionregistrationv1.WebhookClientConfig
+	for i := range desired.Webhooks {
+		desiredConfigs = append(desiredConfigs, &desired.Webhooks[i].ClientConfig)
+	}
+	for i := range existing.Webhooks {
+		existingConfigs = append(existingConfigs, &existing.Webhooks[i].ClientConfig)
+	}
+	keepCABundles(desiredConfigs, existingConfigs)
+	if !needsUpdate(apiequality.Semantic.DeepEqual(existing.Webhooks, desired.Webhooks), existing) {
+		return nil
+	}
+	existingCopy := existing.DeepCopy()
+	existingCopy.Webhooks = desired.Webhooks
+	setManagedBy(existingCopy)
+	_, err = client.Update(context.TODO(), existingCopy, metav1.UpdateOptions{})
+	if err == nil {
+		klog.Infof("Updated drifted ValidatingWebhookConfiguration %v", desired.Name)
+	}
+	return err
+}
+
+// keepCABundles copies the CA bundles of existing webhooks into the desired webhooks without one
+func keepCABundles(desired, existing []*admissionregistrationv1.WebhookClientConfig) {
+	for i, config := range desired {
+		if len(config.CABundle) == 0 && i < len(existing) {
+			config.CABundle = existing[i].CABundle
+		}
+	}
+}
+
+// needsUpdate returns true if the webhooks of obj are not equal to the desired ones or obj is not managed by us
+func needsUpdate(equal bool, obj metav1.Object) bool {
+	return !equal || obj.GetLabels()[managedByLabel] != managedByValue
+}
+
+// setManagedBy sets the managed-by label of obj
+func setManagedBy(obj metav1.Object) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[managedByLabel] = managedByValue
+	obj.SetLabels(labels)
+}
+
+func (r *Reconciler) objectMeta(name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:   name,
+		Labels: map[string]string{managedByLabel: managedByValue},
+	}
+}
+
+func (r *Reconciler) clientConfig(path string) admissionregistrationv1.WebhookClientConfig {
+	port := r.ServicePort
+	return admissionregistrationv1.WebhookClientConfig{
+		Service: &admissionregistrationv1.ServiceReference{
+			Namespace: r.ServiceNamespace,
+			Name:      r.ServiceName,
+			Path:      &path,
+			Port:      &port,
+		},
+		CABundle: r.caBundle(),
+	}
+}
+
+func (r *Reconciler) failurePolicy() *admissionregistrationv1.FailurePolicyType {
+	policy := r.FailurePolicy
+	return &policy
+}
+
+func (r *Reconciler) timeoutSeconds() *int32 {
+	timeout := r.TimeoutSeconds
+	return &timeout
+}
+
+func matchPolicy() *admissionregistrationv1.MatchPolicyType {
+	policy := admissionregistrationv1.Equivalent
+	return &policy
+}
+
+// namespaceSelector skips the objects in kube-system, the same as the static manifest
+func namespaceSelector() *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{
+				Key:      "ns",
+				Operator: metav1.LabelSelectorOpNotIn,
+				Values:   []string{"kube-system"},
+			},
+		},
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registration
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/ocgi/carrier-webhook/pkg/webhook"
+)
+
+func testOptions() Options {
+	client := fake.NewSimpleClientset()
+	whsvr, err := webhook.NewWebhookServer(&webhook.SideCarConfig{}, webhook.NewDefaults(), client,
+		informers.NewSharedInformerFactory(client, 0))
+	if err != nil {
+		panic(err)
+	}
+	registry := whsvr.Registry()
+	return Options{
+		MutatingRules:         registry.MutatingRules(),
+		ValidatingRules:       registry.ValidatingRules(),
+		MutatingWebhookName:   "carrier-mutator",
+		ValidatingWebhookName: "carrier-validator",
+		ServiceName:           "carrier-webhook-service",
+		ServiceNamespace:      "kube-system",
+		ServicePort:           443,
+		FailurePolicy:         admissionregistrationv1.Ignore,
+		TimeoutSeconds:        10,
+		ResyncPeriod:          time.Minute,
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ca := []byte("ca")
+	r := NewReconciler(client, testOptions(), func() []byte { return ca })
+
+	if err := r.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	mutating, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().
+		Get(context.TODO(), "carrier-mutator", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mutating.Webhooks[0].AdmissionReviewVersions; !reflect.DeepEqual(got, []string{"v1", "v1beta1"}) {
+		t.Errorf("desired admissionReviewVersions [v1 v1beta1], got %v", got)
+	}
+	var resources []string
+	for _, rule := range mutating.Webhooks[0].Rules {
+		for _, resource := range rule.Resources {
+			if resource == "*" {
+				t.Errorf("rules should not grant every resource")
+			}
+			resources = append(resources, resource)
+		}
+	}
+	if expected := []string{"gameservers", "gameserversets", "squads", "pods"}; !reflect.DeepEqual(resources, expected) {
+		t.Errorf("desired mutating resources %v, got %v", expected, resources)
+	}
+	if string(mutating.Webhooks[0].ClientConfig.CABundle) != "ca" {
+		t.Errorf("CA bundle should be set")
+	}
+
+	// fix drift
+	mutating.Webhooks[0].AdmissionReviewVersions = []string{"v"}
+	if _, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().
+		Update(context.TODO(), mutating, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// keep the existing CA if unknown
+	ca = nil
+	if err := r.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	mutating, err = client.AdmissionregistrationV1().MutatingWebhookConfigurations().
+		Get(context.TODO(), "carrier-mutator", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mutating.Webhooks[0].AdmissionReviewVersions; !reflect.DeepEqual(got, []string{"v1", "v1beta1"}) {
+		t.Errorf("drift should be fixed, got %v", got)
+	}
+	if string(mutating.Webhooks[0].ClientConfig.CABundle) != "ca" {
+		t.Errorf("existing CA bundle should be kept")
+	}
+
+	if err := r.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.AdmissionregistrationV1().ValidatingWebhookConfigurations().
+		Get(context.TODO(), "carrier-validator", metav1.GetOptions{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("validating webhook configuration should be deleted, got %v", err)
+	}
+	// deleting twice is fine
+	if err := r.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package render shows the mutations of the webhook offline.
+package render
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pmezard/go-difflib/difflib"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
+	carrierutil "github.com/ocgi/carrier/pkg/util"
+
+	"github.com/ocgi/carrier-webhook/pkg/manifest"
+	"github.com/ocgi/carrier-webhook/pkg/util"
+	"github.com/ocgi/carrier-webhook/pkg/webhook"
+)
+
+// Output formats of the rendered objects
+const (
+	OutputObject = "object"
+	OutputPatch  = "patch"
+	OutputDiff   = "diff"
+)
+
+// Outputs are the supported output formats
+var Outputs = []string{OutputObject, OutputPatch, OutputDiff}
+
+// Rendered is an object and its mutated version
+type Rendered struct {
+	Doc      *manifest.Document
+	Original interface{}
+	Mutated  interface{}
+}
+
+// Render mutates the object of doc like the mutating webhook does on creation.
+// If pod is true, the pod of a GameServer, GameServerSet or Squad template is rendered instead,
+// with the side car injected. The side car config is overridden by namespace if not nil.
+func Render(doc *manifest.Document, config *webhook.SideCarConfig, defaults *webhook.Defaults,
+	namespace *corev1.Namespace, pod bool) (*Rendered, error) {
+	if doc.DecodeErr != nil {
+		return nil, fmt.Errorf("decode %v failed: %v", doc, doc.DecodeErr)
+	}
+	r := &Rendered{Doc: doc, Original: doc.Object}
+	switch obj := doc.Object.(type) {
+	case *corev1.Pod:
+		if err := mutatePod(r, obj, config, namespace); err != nil {
+			return nil, err
+		}
+		return r, nil
+	case *v1alpha1.GameServer:
+		gs, _ := webhook.EnsureDefaultForGameServer(obj, defaults)
+		r.Mutated = gs
+		if pod {
+			r.Original = templatePod(gs.ObjectMeta, gs.ObjectMeta, &gs.Spec)
+		}
+	case *v1alpha1.GameServerSet:
+		gsSet, _ := webhook.EnsureDefaultsForGameServerSet(obj, defaults)
+		r.Mutated = gsSet
+		if pod {
+			r.Original = templatePod(gsSet.ObjectMeta, gsSet.Spec.Template.ObjectMeta, &gsSet.Spec.Template.Spec)
+		}
+	case *v1alpha1.Squad:
+		squad, _ := webhook.EnsureDefaultsForSquad(obj, defaults)
+		r.Mutated = squad
+		if pod {
+			r.Original = templatePod(squad.ObjectMeta, squad.Spec.Template.ObjectMeta, &squad.Spec.Template.Spec)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported kind %v of %v", doc.Kind, doc)
+	}
+	if pod {
+		if err := mutatePod(r, r.Original.(*corev1.Pod), config, namespace); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// mutatePod injects the side car into pod, the pod is rejected like the webhook if the side car ports conflict
+func mutatePod(r *Rendered, pod *corev1.Pod, config *webhook.SideCarConfig, namespace *corev1.Namespace) error {
+	mutated, _, _ := webhook.MutatePod(pod, namespace, nil, config)
+	if mutated != pod {
+		if errs := webhook.ValidatePodSideCarPorts(pod, config, namespace); len(errs) != 0 {
+			return fmt.Errorf("%v is rejected: %v", r.Doc, errs.ToAggregate())
+		}
+	}
+	r.Mutated = mutated
+	return nil
+}
+
+// templatePod builds the pod of a game server like the carrier controller, labels and annotations
+// are copied from template. The name is the one of obj as the game server name is generated.
+func templatePod(obj, template metav1.ObjectMeta, spec *v1alpha1.GameServerSpec) *corev1.Pod {
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        obj.Name,
+			Namespace:   obj.Namespace,
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+		},
+		Spec: *spec.Template.Spec.DeepCopy(),
+	}
+	for k, v := range template.Labels {
+		pod.Labels[k] = v
+	}
+	for k, v := range template.Annotations {
+		pod.Annotations[k] = v
+	}
+	pod.Labels[carrierutil.RoleLabelKey] = carrierutil.GameServerLabelRoleValue
+	pod.Labels[carrierutil.GameServerPodLabelKey] = obj.Name
+	return pod
+}
+
+// Write writes the rendered objects in format
+func Write(w io.Writer, format string, rendered []*Rendered) error {
+	var write func(io.Writer, *Rendered) error
+	switch format {
+	case OutputObject:
+		write = writeObject
+	case OutputPatch:
+		write = writePatch
+	case OutputDiff:
+		write = writeDiff
+	default:
+		return fmt.Errorf("unsupported output %q, expect one of %v", format, Outputs)
+	}
+	for i, r := range rendered {
+		if i > 0 && format == OutputObject {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+		if err := write(w, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeObject(w io.Writer, r *Rendered) error {
+	data, err := yaml.Marshal(r.Mutated)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// writePatch writes the JSON patch the webhook returns, one line per object
+func writePatch(w io.Writer, r *Rendered) error {
+	patch, err := util.CreateJsonPatch(r.Original, r.Mutated)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", patch)
+	return err
+}
+
+func writeDiff(w io.Writer, r *Rendered) error {
+	original, err := yaml.Marshal(r.Original)
+	if err != nil {
+		return err
+	}
+	mutated, err := yaml.Marshal(r.Mutated)
+	if err != nil {
+		return err
+	}
+	return difflib.WriteUnifiedDiff(w, difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(original)),
+		B:        difflib.SplitLines(string(mutated)),
+		FromFile: r.Doc.String(),
+		ToFile:   r.Doc.String() + " (mutated)",
+		Context:  3,
+	})
+}
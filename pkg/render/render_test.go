@@ -0,0 +1,184 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package render
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
+
+	"github.com/ocgi/carrier-webhook/pkg/manifest"
+	"github.com/ocgi/carrier-webhook/pkg/webhook"
+)
+
+const manifests = `apiVersion: v1
+kind: Pod
+metadata:
+  name: gs-1
+  labels:
+    carrier.ocgi.dev/gameserver: gs-1
+  annotations:
+    carrier.ocgi.dev/grpc-port: "7000"
+    carrier.ocgi.dev/http-port: "7001"
+spec:
+  containers:
+  - name: server
+    image: game:v1
+---
+apiVersion: carrier.ocgi.dev/v1alpha1
+kind: Squad
+metadata:
+  name: squad
+  namespace: game
+spec:
+  template:
+    metadata:
+      annotations:
+        carrier.ocgi.dev/grpc-port: "8000"
+    spec:
+      template:
+        spec:
+          containers:
+          - name: server
+            image: game:v1
+`
+
+func testConfig() *webhook.SideCarConfig {
+	return &webhook.SideCarConfig{
+		Image:    "sdkserver:v1",
+		CPU:      resource.MustParse("100m"),
+		Memory:   resource.MustParse("100Mi"),
+		HttpPort: 9021,
+		GrpcPort: 9020,
+		LogLevel: 5,
+	}
+}
+
+func loadAndRender(t *testing.T, pod bool) []*Rendered {
+	docs, err := manifest.LoadDocuments("test.yaml", strings.NewReader(manifests), manifest.PodKinds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rendered []*Rendered
+	for _, doc := range docs {
+		r, err := Render(doc, testConfig(), webhook.NewDefaults(), nil, pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rendered = append(rendered, r)
+	}
+	return rendered
+}
+
+func sideCarArgs(t *testing.T, obj interface{}) []string {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		t.Fatalf("expected pod, got %T", obj)
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Image == "sdkserver:v1" {
+			return c.Args
+		}
+	}
+	t.Fatalf("side car not injected into %v", pod.Name)
+	return nil
+}
+
+func TestRender(t *testing.T) {
+	rendered := loadAndRender(t, false)
+	if args := strings.Join(sideCarArgs(t, rendered[0].Mutated), " "); args != "--grpc-port=7000 --http-port=7001 --v=5" {
+		t.Errorf("annotations of pod should override ports, got %v", args)
+	}
+	squad, ok := rendered[1].Mutated.(*v1alpha1.Squad)
+	if !ok || squad.Spec.Template.Spec.Template.Spec.ServiceAccountName == "" {
+		t.Errorf("expected defaulted squad, got %#v", rendered[1].Mutated)
+	}
+
+	rendered = loadAndRender(t, true)
+	if args := strings.Join(sideCarArgs(t, rendered[1].Mutated), " "); args != "--grpc-port=8000 --http-port=9021 --v=5" {
+		t.Errorf("annotations of template should override ports, got %v", args)
+	}
+	if pod := rendered[1].Mutated.(*corev1.Pod); pod.Namespace != "game" {
+		t.Errorf("expected pod in namespace game, got %v", pod.Namespace)
+	}
+}
+
+func TestRenderWithNamespace(t *testing.T) {
+	docs, err := manifest.LoadDocuments("test.yaml", strings.NewReader(manifests), manifest.PodKinds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "game",
+		Annotations: map[string]string{"carrier.ocgi.dev/sdkserver-log-level": "2"},
+	}}
+	r, err := Render(docs[1], testConfig(), webhook.NewDefaults(), namespace, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := strings.Join(sideCarArgs(t, r.Mutated), " "); args != "--grpc-port=8000 --http-port=9021 --v=2" {
+		t.Errorf("namespace should override log level, got %v", args)
+	}
+	sources := r.Mutated.(*corev1.Pod).Annotations[webhook.SideCarConfigSourceKey]
+	if !strings.Contains(sources, "logLevel=namespace") || !strings.Contains(sources, "grpcPort=pod") {
+		t.Errorf("unexpected sources %v", sources)
+	}
+}
+
+func TestRenderPortConflict(t *testing.T) {
+	docs, err := manifest.LoadDocuments("test.yaml", strings.NewReader(manifests), manifest.PodKinds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "game",
+		Annotations: map[string]string{"carrier.ocgi.dev/http-port": "8000"},
+	}}
+	if _, err := Render(docs[1], testConfig(), webhook.NewDefaults(), namespace, true); err == nil ||
+		!strings.Contains(err.Error(), "conflicts with its http and probe port") {
+		t.Errorf("expected port conflict, got %v", err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	rendered := loadAndRender(t, false)
+	for _, c := range []struct {
+		output   string
+		expected []string
+	}{
+		{OutputObject, []string{"image: sdkserver:v1", "\n---\n", "kind: Squad"}},
+		{OutputPatch, []string{`"path":"/spec/containers/1"`, `"path":"/spec/scheduling"`}},
+		{OutputDiff, []string{"--- test.yaml[0] Pod/gs-1\n", "+    image: sdkserver:v1\n", "+  scheduling: MostAllocated\n"}},
+	} {
+		buf := &bytes.Buffer{}
+		if err := Write(buf, c.output, rendered); err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range c.expected {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("%v output should contain %q, got:\n%v", c.output, expected, buf)
+			}
+		}
+	}
+	if err := Write(&bytes.Buffer{}, "yaml", rendered); err == nil {
+		t.Errorf("unsupported output should fail")
+	}
+}
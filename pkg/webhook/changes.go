@@ -0,0 +1,85 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// The keys of audit annotations. The apiserver prefixes them with the webhook name,
+// e.g. carrier-webhook.ocgi.dev/defaulted-fields.
+const (
+	// DefaultedFieldsAuditKey lists the fields set by defaults
+	DefaultedFieldsAuditKey = "defaulted-fields"
+	// SideCarImageAuditKey is the image of the injected side car
+	SideCarImageAuditKey = "sidecar-image"
+	// DeniedByRuleAuditKey lists the field and type of the errors denying the request
+	DeniedByRuleAuditKey = "denied-by-rule"
+	// SideCarSkippedAuditKey is the reason the side car is not injected into a game server pod
+	SideCarSkippedAuditKey = "sidecar-skipped"
+)
+
+// Changes summarizes what EnsureDefault* and EnsurePod changed
+type Changes struct {
+	// DefaultedFields are the paths of the fields set by defaults
+	DefaultedFields []string
+	// SideCarImage is the image of the injected side car, empty if not injected
+	SideCarImage string
+	// SideCarSkipped is the reason the side car is not injected into a game server pod
+	SideCarSkipped string
+}
+
+// defaulted records the field set by defaults
+func (c *Changes) defaulted(fldPath *field.Path) {
+	c.DefaultedFields = append(c.DefaultedFields, fldPath.String())
+}
+
+// AuditAnnotations returns the audit annotations describing the changes, nil if nothing changed.
+func (c *Changes) AuditAnnotations() map[string]string {
+	if c == nil {
+		return nil
+	}
+	var annotations map[string]string
+	if len(c.DefaultedFields) != 0 {
+		annotations = withAnnotation(annotations, DefaultedFieldsAuditKey, strings.Join(c.DefaultedFields, ","))
+	}
+	if c.SideCarImage != "" {
+		annotations = withAnnotation(annotations, SideCarImageAuditKey, c.SideCarImage)
+	}
+	if c.SideCarSkipped != "" {
+		annotations = withAnnotation(annotations, SideCarSkippedAuditKey, c.SideCarSkipped)
+	}
+	return annotations
+}
+
+// deniedByRules returns the sorted unique field and type of errs, e.g. spec.ports[0].containerPort:FieldValueInvalid
+func deniedByRules(errs field.ErrorList) string {
+	rules := sets.NewString()
+	for _, err := range errs {
+		rules.Insert(err.Field + ":" + string(err.Type))
+	}
+	return strings.Join(rules.List(), ",")
+}
+
+func withAnnotation(annotations map[string]string, key, value string) map[string]string {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = value
+	return annotations
+}
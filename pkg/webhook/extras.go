@@ -0,0 +1,142 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// SideCarArgsKey is the annotation of the extra args of side car
+	SideCarArgsKey = "carrier.ocgi.dev/sdkserver-args"
+	envKey         = "carrier.ocgi.dev/sdkserver-env"
+)
+
+// reservedArgs are the flags set by the webhook, mapped to the annotations setting them
+var reservedArgs = map[string]string{
+	"grpc-port": grpcPortKey,
+	"http-port": httpPortKey,
+	"v":         logLevelKey,
+}
+
+// reservedEnv are the env of side car set by the webhook
+var reservedEnv = sets.NewString(gsEnvKey, nsKey)
+
+// parseArgs parses a JSON array of flags like --name=value, only the allowed flags are accepted
+func parseArgs(value string, allowed []string, args *[]string) error {
+	var parsed []string
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		return fmt.Errorf("is not a JSON array of flags: %v", err)
+	}
+	if err := validateArgs(parsed, allowed); err != nil {
+		return err
+	}
+	*args = parsed
+	return nil
+}
+
+// validateArgs returns the error of the first arg which is not an allowed flag like --name=value,
+// the flags set by the webhook are never allowed
+func validateArgs(args, allowed []string) error {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") || len(arg) == 2 || arg[2] == '=' {
+			return fmt.Errorf("has %q which is not a flag like --name=value", arg)
+		}
+		name := strings.SplitN(arg[2:], "=", 2)[0]
+		if key, ok := reservedArgs[name]; ok {
+			return fmt.Errorf("has %q which is set by %v", arg, key)
+		}
+		if !contains(allowed, name) {
+			return fmt.Errorf("has %q which is not allowed, the allowed flags are %v", arg, allowed)
+		}
+	}
+	return nil
+}
+
+// parseEnv parses a JSON array of env, see ValidateSideCarEnv
+func parseEnv(value string, env *[]corev1.EnvVar) error {
+	var parsed []corev1.EnvVar
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&parsed); err != nil {
+		return fmt.Errorf("is not a JSON array of env: %v", err)
+	}
+	if errs := ValidateSideCarEnv(parsed, field.NewPath("env")); len(errs) != 0 {
+		return fmt.Errorf("is not a valid env: %v", errs.ToAggregate())
+	}
+	*env = parsed
+	return nil
+}
+
+// ValidateSideCarEnv validates the extra env of side car. An env is a literal value, or the key of
+// a ConfigMap or Secret in the namespace of pod, the env set by the webhook can not be overridden.
+func ValidateSideCarEnv(env []corev1.EnvVar, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	names := sets.NewString()
+	for i, e := range env {
+		envPath := fldPath.Index(i)
+		for _, msg := range validation.IsEnvVarName(e.Name) {
+			errs = append(errs, field.Invalid(envPath.Child("name"), e.Name, msg))
+		}
+		if reservedEnv.Has(e.Name) {
+			errs = append(errs, field.Forbidden(envPath.Child("name"), fmt.Sprintf("%v is set by the webhook", e.Name)))
+		}
+		if names.Has(e.Name) {
+			errs = append(errs, field.Duplicate(envPath.Child("name"), e.Name))
+		}
+		names.Insert(e.Name)
+		if e.ValueFrom == nil {
+			continue
+		}
+		from := e.ValueFrom
+		fromPath := envPath.Child("valueFrom")
+		switch {
+		case e.Value != "":
+			errs = append(errs, field.Forbidden(envPath.Child("value"), "may not be set with valueFrom"))
+		case from.FieldRef != nil || from.ResourceFieldRef != nil:
+			errs = append(errs, field.Forbidden(fromPath, "only configMapKeyRef and secretKeyRef are supported"))
+		case from.ConfigMapKeyRef != nil && from.SecretKeyRef != nil:
+			errs = append(errs, field.Forbidden(fromPath, "may not have both configMapKeyRef and secretKeyRef"))
+		case from.ConfigMapKeyRef != nil:
+			errs = append(errs, validateKeyRef(from.ConfigMapKeyRef.Name, from.ConfigMapKeyRef.Key,
+				fromPath.Child("configMapKeyRef"))...)
+		case from.SecretKeyRef != nil:
+			errs = append(errs, validateKeyRef(from.SecretKeyRef.Name, from.SecretKeyRef.Key,
+				fromPath.Child("secretKeyRef"))...)
+		default:
+			errs = append(errs, field.Required(fromPath, "configMapKeyRef or secretKeyRef is required"))
+		}
+	}
+	return errs
+}
+
+func validateKeyRef(name, key string, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if name == "" {
+		errs = append(errs, field.Required(fldPath.Child("name"), ""))
+	}
+	if key == "" {
+		errs = append(errs, field.Required(fldPath.Child("key"), ""))
+	}
+	return errs
+}
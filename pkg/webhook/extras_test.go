@@ -0,0 +1,137 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestParseArgs(t *testing.T) {
+	cases := []struct {
+		value   string
+		desired []string
+		err     string
+	}{
+		{
+			value:   `["--feature-gates=A=true,B=false"]`,
+			desired: []string{"--feature-gates=A=true,B=false"},
+		},
+		{
+			value: `--feature-gates=A=true`,
+			err:   "is not a JSON array of flags",
+		},
+		{
+			value: `["feature-gates=A=true"]`,
+			err:   `has "feature-gates=A=true" which is not a flag like --name=value`,
+		},
+		{
+			value: `["--v=10"]`,
+			err:   `has "--v=10" which is set by carrier.ocgi.dev/sdkserver-log-level`,
+		},
+		{
+			value: `["--kubeconfig=/tmp/config"]`,
+			err:   `has "--kubeconfig=/tmp/config" which is not allowed, the allowed flags are [feature-gates]`,
+		},
+	}
+	for _, c := range cases {
+		var args []string
+		err := parseArgs(c.value, []string{"feature-gates"}, &args)
+		if c.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), c.err) {
+				t.Errorf("%v: desired error %v, got %v", c.value, c.err, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(args, c.desired) {
+			t.Errorf("%v: desired %v, got %v %v", c.value, c.desired, args, err)
+		}
+	}
+}
+
+func TestValidateSideCarEnv(t *testing.T) {
+	env := []corev1.EnvVar{
+		{Name: "SDK_MODE", Value: "fast"},
+		{Name: "SDK_TOKEN", ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "sdk"},
+				Key:                  "token",
+			},
+		}},
+		{Name: "POD_NAMESPACE", Value: "other"},
+		{Name: "SDK_MODE", Value: "slow"},
+		{Name: "NODE", ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"},
+		}},
+		{Name: "SDK_CONFIG", ValueFrom: &corev1.EnvVarSource{
+			ConfigMapKeyRef: &corev1.ConfigMapKeySelector{Key: "config"},
+		}},
+		{Name: "1SDK", Value: "1"},
+	}
+	desired := []string{
+		"env[2].name",
+		"env[3].name",
+		"env[4].valueFrom",
+		"env[5].valueFrom.configMapKeyRef.name",
+		"env[6].name",
+	}
+	var got []string
+	for _, err := range ValidateSideCarEnv(env, field.NewPath("env")) {
+		got = append(got, err.Field)
+	}
+	if !reflect.DeepEqual(got, desired) {
+		t.Errorf("desired errors of %v, got %v", desired, got)
+	}
+}
+
+func TestMutatePodExtras(t *testing.T) {
+	config := testGlobalConfig()
+	config.AllowedArgs = []string{"feature-gates"}
+	config.Args = []string{"--metrics"}
+	config.Env = []corev1.EnvVar{{Name: "SDK_MODE", Value: "fast"}, {Name: "SDK_REGION", Value: "eu"}}
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "game", Annotations: map[string]string{
+			envKey: `[{"name": "SDK_MODE", "value": "slow"}]`,
+		}},
+	}
+	pod := defaultTestPod().Obj()
+	pod.Annotations = map[string]string{
+		SideCarArgsKey: `["--feature-gates=A=true"]`,
+		envKey:         `[{"name": "SDK_TOKEN", "valueFrom": {"secretKeyRef": {"name": "sdk", "key": "token"}}}]`,
+	}
+	mutated, _, warnings := MutatePod(pod, namespace, nil, config)
+	if len(warnings) != 0 {
+		t.Errorf("unexpected warnings %v", warnings)
+	}
+	sideCar := mutated.Spec.Containers[len(mutated.Spec.Containers)-1]
+	desiredArgs := "--grpc-port=9020 --http-port=9021 --v=5 --metrics --feature-gates=A=true"
+	if args := strings.Join(sideCar.Args, " "); args != desiredArgs {
+		t.Errorf("desired args %v, got %v", desiredArgs, args)
+	}
+	var env []string
+	for _, e := range sideCar.Env {
+		env = append(env, e.Name+"="+e.Value)
+	}
+	// the env annotation of pod replaces the one of namespace, and is merged into the env of config
+	desired := []string{"GAMESERVER_NAME=" + pod.Name, "POD_NAMESPACE=", "SDK_MODE=fast", "SDK_REGION=eu", "SDK_TOKEN="}
+	if !reflect.DeepEqual(env, desired) {
+		t.Errorf("desired env %v, got %v", desired, env)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	configv1alpha1 "github.com/ocgi/carrier-webhook/pkg/apis/config/v1alpha1"
+)
+
+// Result is the outcome of mutating or validating an object, nil allows the object unchanged.
+type Result struct {
+	// Patch is the JSON patch of mutating
+	Patch []byte
+	// Errors are the field errors of validating
+	Errors field.ErrorList
+	// Warnings are shown to users even if the request is denied
+	Warnings []string
+	// AuditAnnotations are recorded in the audit log of apiserver
+	AuditAnnotations map[string]string
+}
+
+// Handler admits the objects of one kind. A non-nil error denies the request.
+type Handler interface {
+	// Default mutates the object of the request
+	Default(req *admissionv1.AdmissionRequest) (*Result, error)
+	// ValidateCreate validates the object to create
+	ValidateCreate(req *admissionv1.AdmissionRequest) (*Result, error)
+	// ValidateUpdate validates the object to update against the old one
+	ValidateUpdate(req *admissionv1.AdmissionRequest) (*Result, error)
+	// ValidateDelete validates the old object to delete
+	ValidateDelete(req *admissionv1.AdmissionRequest) (*Result, error)
+}
+
+// NopHandler allows every request, handlers embed it to implement only a part of Handler.
+type NopHandler struct{}
+
+// Default allows the request unchanged
+func (NopHandler) Default(*admissionv1.AdmissionRequest) (*Result, error) { return nil, nil }
+
+// ValidateCreate allows the request
+func (NopHandler) ValidateCreate(*admissionv1.AdmissionRequest) (*Result, error) { return nil, nil }
+
+// ValidateUpdate allows the request
+func (NopHandler) ValidateUpdate(*admissionv1.AdmissionRequest) (*Result, error) { return nil, nil }
+
+// ValidateDelete allows the request
+func (NopHandler) ValidateDelete(*admissionv1.AdmissionRequest) (*Result, error) { return nil, nil }
+
+// Registration describes the handler of a kind and the webhook rules sending it requests
+type Registration struct {
+	// Resource is the plural resource of the kind, e.g. squads
+	Resource string
+	// MutateOperations are sent to Default, the kind is not mutated if empty
+	MutateOperations []admissionregistrationv1.OperationType
+	// ValidateOperations are sent to Validate*, the kind is not validated if empty
+	ValidateOperations []admissionregistrationv1.OperationType
+	// Handler admits the objects of the kind
+	Handler Handler
+}
+
+// UnhandledKindPolicy decides the requests of kinds without handler, the policies are declared with
+// the configuration file
+type UnhandledKindPolicy = configv1alpha1.UnhandledKindPolicy
+
+const (
+	// UnhandledKindAllow allows the requests unchanged
+	UnhandledKindAllow = configv1alpha1.UnhandledKindAllow
+	// UnhandledKindDeny denies the requests
+	UnhandledKindDeny = configv1alpha1.UnhandledKindDeny
+)
+
+// UnhandledKindPolicies are the supported policies
+var UnhandledKindPolicies = configv1alpha1.UnhandledKindPolicies
+
+// Registry holds the handlers keyed by GroupVersionKind
+type Registry struct {
+	mu            sync.RWMutex
+	registrations map[schema.GroupVersionKind]Registration
+	// UnhandledKindPolicy decides the requests of kinds without handler, set before serving
+	UnhandledKindPolicy UnhandledKindPolicy
+}
+
+// NewRegistry creates an empty registry allowing unhandled kinds
+func NewRegistry() *Registry {
+	return &Registry{
+		registrations:       map[schema.GroupVersionKind]Registration{},
+		UnhandledKindPolicy: UnhandledKindAllow,
+	}
+}
+
+// Register adds the handler of gvk, a kind can only be registered once.
+func (r *Registry) Register(gvk schema.GroupVersionKind, reg Registration) error {
+	if reg.Handler == nil {
+		return fmt.Errorf("handler of %v is nil", gvk)
+	}
+	if reg.Resource == "" {
+		return fmt.Errorf("resource of %v is empty", gvk)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.registrations[gvk]; ok {
+		return fmt.Errorf("handler of %v is already registered", gvk)
+	}
+	r.registrations[gvk] = reg
+	return nil
+}
+
+// mutatingHandler returns the handler mutating gvk
+func (r *Registry) mutatingHandler(gvk schema.GroupVersionKind) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reg, ok := r.registrations[gvk]
+	if !ok || len(reg.MutateOperations) == 0 {
+		return nil, false
+	}
+	return reg.Handler, true
+}
+
+// validatingHandler returns the handler validating gvk
+func (r *Registry) validatingHandler(gvk schema.GroupVersionKind) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reg, ok := r.registrations[gvk]
+	if !ok || len(reg.ValidateOperations) == 0 {
+		return nil, false
+	}
+	return reg.Handler, true
+}
+
+// MutatingRules returns the rules of the kinds mutated
+func (r *Registry) MutatingRules() []admissionregistrationv1.RuleWithOperations {
+	return r.rules(func(reg Registration) []admissionregistrationv1.OperationType { return reg.MutateOperations })
+}
+
+// ValidatingRules returns the rules of the kinds validated
+func (r *Registry) ValidatingRules() []admissionregistrationv1.RuleWithOperations {
+	return r.rules(func(reg Registration) []admissionregistrationv1.OperationType { return reg.ValidateOperations })
+}
+
+// rules returns one rule per kind, sorted by group version and resource to keep them stable
+func (r *Registry) rules(
+	operations func(Registration) []admissionregistrationv1.OperationType) []admissionregistrationv1.RuleWithOperations {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var kinds []kindRule
+	for gvk, reg := range r.registrations {
+		if ops := operations(reg); len(ops) != 0 {
+			kinds = append(kinds, kindRule{gvk.GroupVersion(), reg.Resource, ops})
+		}
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if kinds[i].groupVersion != kinds[j].groupVersion {
+			return kinds[i].groupVersion.String() < kinds[j].groupVersion.String()
+		}
+		return kinds[i].resource < kinds[j].resource
+	})
+	return toRules(kinds)
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"fmt"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var widgetKind = schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+
+// widgetHandler denies the widgets to delete
+type widgetHandler struct {
+	NopHandler
+}
+
+func (widgetHandler) ValidateDelete(*admissionv1.AdmissionRequest) (*Result, error) {
+	errs := field.ErrorList{field.Forbidden(field.NewPath("metadata", "name"), "widgets cannot be deleted")}
+	return &Result{Errors: errs}, errs.ToAggregate()
+}
+
+func widgetRequest(kind schema.GroupVersionKind, operation admissionv1.Operation) *admissionv1.AdmissionRequest {
+	return &admissionv1.AdmissionRequest{
+		UID:       "test",
+		Kind:      metav1.GroupVersionKind{Group: kind.Group, Version: kind.Version, Kind: kind.Kind},
+		Name:      "widget",
+		Namespace: "default",
+		Operation: operation,
+		Object:    runtime.RawExtension{Raw: []byte(fmt.Sprintf(`{"kind":%q}`, kind.Kind))},
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	whsvr := newTestWebhookServer()
+	registry := whsvr.Registry()
+	reg := Registration{
+		Resource:           "widgets",
+		ValidateOperations: []admissionregistrationv1.OperationType{admissionregistrationv1.Delete},
+		Handler:            widgetHandler{},
+	}
+	if err := registry.Register(widgetKind, reg); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register(widgetKind, reg); err == nil {
+		t.Errorf("registering a kind twice should fail")
+	}
+	rules := registry.ValidatingRules()
+	if len(rules) != 4 || rules[3].Resources[0] != "widgets" || rules[3].Operations[0] != admissionregistrationv1.Delete {
+		t.Errorf("desired widgets in validating rules, got %+v", rules)
+	}
+	for _, rule := range registry.MutatingRules() {
+		if rule.Resources[0] == "widgets" {
+			t.Errorf("widgets should not be mutated")
+		}
+	}
+
+	if resp := doReview(t, whsvr, ValidatePath, widgetRequest(widgetKind, admissionv1.Delete)); resp.Allowed {
+		t.Errorf("widget handler should deny delete")
+	}
+	if resp := doReview(t, whsvr, ValidatePath, widgetRequest(widgetKind, admissionv1.Create)); !resp.Allowed {
+		t.Errorf("widget handler should allow create, got %v", resp.Result)
+	}
+
+	unknownKind := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Unknown"}
+	for _, c := range []struct {
+		policy  UnhandledKindPolicy
+		allowed bool
+	}{
+		{UnhandledKindAllow, true},
+		{UnhandledKindDeny, false},
+	} {
+		registry.UnhandledKindPolicy = c.policy
+		for _, path := range []string{MutatePath, ValidatePath} {
+			resp := doReview(t, whsvr, path, widgetRequest(unknownKind, admissionv1.Create))
+			if resp.Allowed != c.allowed {
+				t.Errorf("%v: desired allowed %v with policy %v, got %v", path, c.allowed, c.policy, resp.Result)
+			}
+		}
+		// widgets are not mutated, they are unhandled by the mutating webhook
+		resp := doReview(t, whsvr, MutatePath, widgetRequest(widgetKind, admissionv1.Create))
+		if resp.Allowed != c.allowed {
+			t.Errorf("desired allowed %v of mutating widget with policy %v, got %v", c.allowed, c.policy, resp.Result)
+		}
+	}
+}
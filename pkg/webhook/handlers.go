@@ -0,0 +1,121 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
+)
+
+// registerBuiltinHandlers registers the handlers of GameServer, GameServerSet, Squad and Pod
+func (whsvr *webhookServer) registerBuiltinHandlers() error {
+	builtins := map[string]Registration{
+		"GameServer": {
+			Resource:           "gameservers",
+			MutateOperations:   createAndUpdate,
+			ValidateOperations: createAndUpdate,
+			Handler:            &gameServerHandler{whsvr: whsvr},
+		},
+		"GameServerSet": {
+			Resource:           "gameserversets",
+			MutateOperations:   createAndUpdate,
+			ValidateOperations: createAndUpdate,
+			Handler:            &gameServerSetHandler{whsvr: whsvr},
+		},
+		"Squad": {
+			Resource:           "squads",
+			MutateOperations:   createAndUpdate,
+			ValidateOperations: createAndUpdate,
+			Handler:            &squadHandler{whsvr: whsvr},
+		},
+	}
+	for kind, reg := range builtins {
+		if err := whsvr.registry.Register(v1alpha1.SchemeGroupVersion.WithKind(kind), reg); err != nil {
+			return err
+		}
+	}
+	return whsvr.registry.Register(corev1.SchemeGroupVersion.WithKind("Pod"), Registration{
+		Resource:         "pods",
+		MutateOperations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+		Handler:          &podHandler{whsvr: whsvr},
+	})
+}
+
+// gameServerHandler creates the service account and sets defaults of GameServer
+type gameServerHandler struct {
+	NopHandler
+	whsvr *webhookServer
+}
+
+func (h *gameServerHandler) Default(req *admissionv1.AdmissionRequest) (*Result, error) {
+	return h.whsvr.forGameServer(req)
+}
+
+func (h *gameServerHandler) ValidateCreate(req *admissionv1.AdmissionRequest) (*Result, error) {
+	return h.whsvr.validateForGameServer(req)
+}
+
+func (h *gameServerHandler) ValidateUpdate(req *admissionv1.AdmissionRequest) (*Result, error) {
+	return h.whsvr.validateForGameServer(req)
+}
+
+// gameServerSetHandler creates the service account and sets defaults of GameServerSet
+type gameServerSetHandler struct {
+	NopHandler
+	whsvr *webhookServer
+}
+
+func (h *gameServerSetHandler) Default(req *admissionv1.AdmissionRequest) (*Result, error) {
+	return h.whsvr.forGameServerSet(req)
+}
+
+func (h *gameServerSetHandler) ValidateCreate(req *admissionv1.AdmissionRequest) (*Result, error) {
+	return h.whsvr.validateForGameServerSet(req)
+}
+
+func (h *gameServerSetHandler) ValidateUpdate(req *admissionv1.AdmissionRequest) (*Result, error) {
+	return h.whsvr.validateForGameServerSet(req)
+}
+
+// squadHandler creates the service account and sets defaults of Squad
+type squadHandler struct {
+	NopHandler
+	whsvr *webhookServer
+}
+
+func (h *squadHandler) Default(req *admissionv1.AdmissionRequest) (*Result, error) {
+	return h.whsvr.forSquad(req)
+}
+
+func (h *squadHandler) ValidateCreate(req *admissionv1.AdmissionRequest) (*Result, error) {
+	return h.whsvr.validateForSquad(req)
+}
+
+func (h *squadHandler) ValidateUpdate(req *admissionv1.AdmissionRequest) (*Result, error) {
+	return h.whsvr.validateForSquad(req)
+}
+
+// podHandler injects the side car into game server pods, pods are not validated
+type podHandler struct {
+	NopHandler
+	whsvr *webhookServer
+}
+
+func (h *podHandler) Default(req *admissionv1.AdmissionRequest) (*Result, error) {
+	return forPod(req, h.whsvr.sideCarConfig(), h.whsvr.namespace, h.whsvr.serviceAccount)
+}
@@ -21,27 +21,33 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
 	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/client-go/informers"
+	rbacinformers "k8s.io/client-go/informers/rbac/v1"
 	"k8s.io/client-go/kubernetes"
 	v1 "k8s.io/client-go/listers/core/v1"
 	rbaclisterv1 "k8s.io/client-go/listers/rbac/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 
+	"github.com/ocgi/carrier-webhook/pkg/audit"
+	"github.com/ocgi/carrier-webhook/pkg/metrics"
 	"github.com/ocgi/carrier-webhook/pkg/util"
-	"github.com/ocgi/carrier/pkg/apis/carrier"
 	"github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
 )
 
@@ -73,52 +79,115 @@ const (
 type SideCarConfig struct {
 	// Image describes the image version
 	Image string
-	// CPU if cpu config of side car
+	// CPU is the cpu request of side car
 	CPU resource.Quantity
-	// Memory if memory config of side car
+	// Memory is the memory request of side car
 	Memory resource.Quantity
+	// EphemeralStorage is the ephemeral storage request of side car
+	EphemeralStorage resource.Quantity
+	// CPULimit is the cpu limit of side car, the request if zero
+	CPULimit resource.Quantity
+	// MemoryLimit is the memory limit of side car, the request if zero
+	MemoryLimit resource.Quantity
+	// EphemeralStorageLimit is the ephemeral storage limit of side car, the request if zero
+	EphemeralStorageLimit resource.Quantity
 	// HttpPort is the port for http
 	HttpPort int
 	// GrpcPort is the port for grpc
 	GrpcPort int
+	// Probes are the probes of side car on its http port
+	Probes SideCarProbes
+	// LogLevel is the klog verbosity of side car
+	LogLevel int
+	// AllowedArgs are the flags of side car allowed in the args annotation, e.g. feature-gates, none if empty
+	AllowedArgs []string
+	// Args are the extra args of side car
+	Args []string
+	// ExtraArgs are the args of side car overridden by namespace and pod, appended to Args
+	ExtraArgs []string
+	// Env is the extra env of side car
+	Env []corev1.EnvVar
+	// ExtraEnv is the env of side car overridden by namespace and pod, replacing the one of Env with the same name
+	ExtraEnv []corev1.EnvVar
+	// Token is the projected service account token injected if the pod has none
+	Token TokenConfig
+	// Template is merged into the side car, only the built-in side car is injected if nil
+	Template *corev1.Container
+	// InjectionDisabled is true if the side car is not injected, set by InjectionOptIn and InjectKey
+	InjectionDisabled bool
 }
 
 type webhookServer struct {
 	*http.Server
 	config            *SideCarConfig
+	defaults          *Defaults
 	saLister          v1.ServiceAccountLister
 	roleBindingLister rbaclisterv1.RoleBindingLister
+	namespaceLister   v1.NamespaceLister
+	clusterRoleLister rbaclisterv1.ClusterRoleLister
 	saSynced          cache.InformerSynced
 	roleBindingSynced cache.InformerSynced
+	namespaceSynced   cache.InformerSynced
+	clusterRoleSynced cache.InformerSynced
 	kubeClient        kubernetes.Interface
+	auditLogger       *audit.Logger
+	registry          *Registry
+	templateWatcher   *SideCarTemplateWatcher
 }
 
 func init() {
 	_ = corev1.AddToScheme(runtimeScheme)
 	_ = admissionregistrationv1beta1.AddToScheme(runtimeScheme)
+	_ = admissionv1.AddToScheme(runtimeScheme)
+	_ = admissionv1beta1.AddToScheme(runtimeScheme)
 	runtimeScheme.AddKnownTypes(v1alpha1.SchemeGroupVersion,
 		&v1alpha1.GameServer{}, &v1alpha1.GameServerSet{}, &v1alpha1.Squad{})
 }
 
-// NewWebhookServer creates a new server
-func NewWebhookServer(config *SideCarConfig, kubeClient kubernetes.Interface,
-	factory informers.SharedInformerFactory) *webhookServer {
+// NewWebhookServer creates a new server with the built-in handlers, the defaults are set by the mutating webhook
+func NewWebhookServer(config *SideCarConfig, defaults *Defaults, kubeClient kubernetes.Interface,
+	factory informers.SharedInformerFactory) (*webhookServer, error) {
 	saInformer := factory.Core().V1().ServiceAccounts()
 	roleBindingInformer := factory.Rbac().V1().RoleBindings()
-	return &webhookServer{
+	namespaceInformer := factory.Core().V1().Namespaces()
+	// only the default cluster role is watched for the readiness check
+	clusterRoleInformer := factory.InformerFor(&rbacv1.ClusterRole{},
+		func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+			return rbacinformers.NewFilteredClusterRoleInformer(client, resync, cache.Indexers{},
+				func(options *metav1.ListOptions) {
+					options.FieldSelector = fields.OneTermEqualSelector("metadata.name", defaultClusterRoleName).String()
+				})
+		})
+	whsvr := &webhookServer{
 		config:            config,
+		defaults:          defaults,
 		saLister:          saInformer.Lister(),
 		roleBindingLister: roleBindingInformer.Lister(),
+		namespaceLister:   namespaceInformer.Lister(),
+		clusterRoleLister: rbaclisterv1.NewClusterRoleLister(clusterRoleInformer.GetIndexer()),
 		kubeClient:        kubeClient,
 		saSynced:          saInformer.Informer().HasSynced,
 		roleBindingSynced: roleBindingInformer.Informer().HasSynced,
+		namespaceSynced:   namespaceInformer.Informer().HasSynced,
+		clusterRoleSynced: clusterRoleInformer.HasSynced,
+		registry:          NewRegistry(),
 	}
+	if err := whsvr.registerBuiltinHandlers(); err != nil {
+		return nil, fmt.Errorf("register built-in handlers failed: %v", err)
+	}
+	return whsvr, nil
+}
+
+// Registry returns the handlers of the server, more kinds can be registered before serving.
+func (whsvr *webhookServer) Registry() *Registry {
+	return whsvr.registry
 }
 
 // WaitForCacheSynced wait the cache synced or die
 func (whsvr *webhookServer) WaitForCacheSynced(stop <-chan struct{}) {
 	klog.V(4).Info("Wait for cache sync")
-	if !cache.WaitForCacheSync(stop, whsvr.saSynced, whsvr.roleBindingSynced) {
+	if !cache.WaitForCacheSync(stop, whsvr.saSynced, whsvr.roleBindingSynced, whsvr.namespaceSynced,
+		whsvr.clusterRoleSynced, whsvr.templateWatcher.HasSynced) {
 		klog.Fatal("Sync cache failed")
 	}
 	if err := whsvr.createDefaultClusterRole(); err != nil {
@@ -126,8 +195,73 @@ func (whsvr *webhookServer) WaitForCacheSynced(stop <-chan struct{}) {
 	}
 }
 
+// SetAuditLogger records every decision to l
+func (whsvr *webhookServer) SetAuditLogger(l *audit.Logger) {
+	whsvr.auditLogger = l
+}
+
+// SetSideCarTemplateWatcher merges the template of w into the side car
+func (whsvr *webhookServer) SetSideCarTemplateWatcher(w *SideCarTemplateWatcher) {
+	whsvr.templateWatcher = w
+}
+
+// HasSynced returns true if the informers have synced
+func (whsvr *webhookServer) HasSynced() bool {
+	return whsvr.saSynced() && whsvr.roleBindingSynced() && whsvr.namespaceSynced() &&
+		whsvr.clusterRoleSynced() && whsvr.templateWatcher.HasSynced()
+}
+
+// sideCarConfig returns the side car config of a request with the current template
+func (whsvr *webhookServer) sideCarConfig() *SideCarConfig {
+	config := *whsvr.config
+	config.Template = whsvr.templateWatcher.Template()
+	return &config
+}
+
+// namespace returns the namespace of the lister, nil if it is not found
+func (whsvr *webhookServer) namespace(name string) *corev1.Namespace {
+	ns, err := whsvr.namespaceLister.Get(name)
+	if err != nil {
+		klog.V(4).Infof("Get namespace %v failed, its side car overrides are ignored: %v", name, err)
+		return nil
+	}
+	return ns
+}
+
+// serviceAccount returns the service account from the informer, nil if not found
+func (whsvr *webhookServer) serviceAccount(namespace, name string) *corev1.ServiceAccount {
+	if name == "" {
+		name = "default"
+	}
+	sa, err := whsvr.saLister.ServiceAccounts(namespace).Get(name)
+	if err != nil {
+		klog.V(4).Infof("Get service account %v/%v failed: %v", namespace, name, err)
+		return nil
+	}
+	return sa
+}
+
+// CheckInformerSync is the readiness check of informers
+func (whsvr *webhookServer) CheckInformerSync(*http.Request) error {
+	if !whsvr.HasSynced() {
+		return fmt.Errorf("service account, role binding, namespace, cluster role and side car template informers " +
+			"have not synced")
+	}
+	return nil
+}
+
+// CheckClusterRole is the readiness check of the default cluster role, read from the informer
+func (whsvr *webhookServer) CheckClusterRole(*http.Request) error {
+	_, err := whsvr.clusterRoleLister.Get(defaultClusterRoleName)
+	if err != nil {
+		return fmt.Errorf("get cluster role %v failed: %v", defaultClusterRoleName, err)
+	}
+	return nil
+}
+
 // Serve method for webhook server
 func (whsvr *webhookServer) Serve(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	var body []byte
 	if r.Body != nil {
 		if data, err := ioutil.ReadAll(r.Body); err == nil {
@@ -149,29 +283,33 @@ func (whsvr *webhookServer) Serve(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var admissionResponse *admissionv1.AdmissionResponse
-	ar := admissionv1.AdmissionReview{}
-	if _, _, err := deserializer.Decode(body, nil, &ar); err != nil {
+	ar, gvk, err := decodeReview(body)
+	if err != nil {
 		klog.Errorf("Can't decode body: %v", err)
-		admissionResponse = &admissionv1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
-	} else {
-		if r.URL.Path == "/mutate" {
-			admissionResponse = whsvr.mutate(&ar)
-		}
+		http.Error(w, fmt.Sprintf("could not decode body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if ar.Request == nil {
+		klog.Error("empty request of AdmissionReview")
+		http.Error(w, "empty request of AdmissionReview", http.StatusBadRequest)
+		return
+	}
+	var admissionResponse *admissionv1.AdmissionResponse
+	switch r.URL.Path {
+	case MutatePath:
+		admissionResponse = whsvr.mutate(ar)
+	case ValidatePath:
+		admissionResponse = whsvr.validate(ar)
 	}
-
 	if admissionResponse != nil {
 		ar.Response = admissionResponse
-		if ar.Request != nil {
-			ar.Response.UID = ar.Request.UID
-		}
+		ar.Response.UID = ar.Request.UID
 	}
+	webhookName := strings.TrimPrefix(r.URL.Path, "/")
+	metrics.ObserveAdmission(webhookName, ar.Request, admissionResponse, start)
+	whsvr.auditLogger.Log(webhookName, ar.Request, admissionResponse, start)
 
-	resp, err := json.Marshal(ar)
+	resp, err := json.Marshal(encodeReview(ar, gvk))
 	if err != nil {
 		klog.Errorf("Can't encode response: %v", err)
 		http.Error(w, fmt.Sprintf("could not encode response: %v", err), http.StatusInternalServerError)
@@ -182,55 +320,99 @@ func (whsvr *webhookServer) Serve(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// mutate will validate and mutate GameSerer, GameServerSet, Squad
+// mutate calls the handler of the kind to set defaults of GameSerer, GameServerSet, Squad and inject sidecar to Pod
 func (whsvr *webhookServer) mutate(ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
 	req := ar.Request
 
-	klog.Infof("AdmissionReview for Kind=%v, Namespace=%v Name=%v UID=%v Operation=%v UserInfo=%v",
+	klog.Infof("Mutating AdmissionReview for Kind=%v, Namespace=%v Name=%v UID=%v Operation=%v UserInfo=%v",
 		req.Kind, req.Namespace, req.Name, req.UID, req.Operation, req.UserInfo)
+	handler, ok := whsvr.registry.mutatingHandler(requestKind(req))
+	if !ok {
+		return whsvr.unhandled(req)
+	}
+	res, err := handler.Default(req)
+	if res != nil && len(res.Patch) != 0 {
+		klog.V(6).Infof("Final patch %+v", string(res.Patch))
+	}
+	return toAdmissionResponse(req, res, err)
+}
+
+// validate calls the handler of the kind to validate the final object after all mutations
+func (whsvr *webhookServer) validate(ar *admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	req := ar.Request
+
+	klog.Infof("Validating AdmissionReview for Kind=%v, Namespace=%v Name=%v UID=%v Operation=%v UserInfo=%v",
+		req.Kind, req.Namespace, req.Name, req.UID, req.Operation, req.UserInfo)
+	handler, ok := whsvr.registry.validatingHandler(requestKind(req))
+	if !ok {
+		return whsvr.unhandled(req)
+	}
 	var err error
-	var patch []byte
-	var el field.ErrorList
-	switch req.Kind.Kind {
-	case "GameServer":
-		patch, el, err = whsvr.forGameServer(req)
-	case "GameServerSet":
-		patch, el, err = whsvr.forGameServerSet(req)
-	case "Squad":
-		patch, el, err = whsvr.forSquad(req)
-	case "Pod":
-		patch, el, err = forPod(req, whsvr.config)
-	}
-	if len(patch) != 0 {
-		klog.V(6).Infof("Final patch %+v", string(patch))
-	}
-	result := metav1.Status{
+	var res *Result
+	switch req.Operation {
+	case admissionv1.Create:
+		res, err = handler.ValidateCreate(req)
+	case admissionv1.Update:
+		res, err = handler.ValidateUpdate(req)
+	case admissionv1.Delete:
+		res, err = handler.ValidateDelete(req)
+	}
+	return toAdmissionResponse(req, res, err)
+}
+
+// unhandled decides the request of a kind without handler by the policy of registry
+func (whsvr *webhookServer) unhandled(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if whsvr.registry.UnhandledKindPolicy == UnhandledKindDeny {
+		return toAdmissionResponse(req, nil, fmt.Errorf("kind %v is not handled by the webhook", requestKind(req)))
+	}
+	klog.V(4).Infof("Allow unhandled kind %v of %v/%v", requestKind(req), req.Namespace, req.Name)
+	return toAdmissionResponse(req, nil, nil)
+}
+
+func requestKind(req *admissionv1.AdmissionRequest) schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: req.Kind.Group, Version: req.Kind.Version, Kind: req.Kind.Kind}
+}
+
+// toAdmissionResponse builds the response from the result of mutating or validating
+func toAdmissionResponse(req *admissionv1.AdmissionRequest, res *Result, err error) *admissionv1.AdmissionResponse {
+	if res == nil {
+		res = &Result{}
+	}
+	status := metav1.Status{
 		Details: &metav1.StatusDetails{
-			Name:  ar.Request.Name,
-			Group: ar.Request.Kind.Group,
-			Kind:  ar.Request.Kind.Kind,
-			UID:   ar.Request.UID,
+			Name:  req.Name,
+			Group: req.Kind.Group,
+			Kind:  req.Kind.Kind,
+			UID:   req.UID,
 		},
 	}
 	if err != nil {
 		klog.Error(err)
-		result.Code = 400
-		result.Message = err.Error()
-		finalErr := errors.NewInvalid(schema.GroupKind{Group: carrier.GroupName, Kind: ar.Kind}, ar.Request.Name, el)
-		result.Details.Causes = finalErr.ErrStatus.Details.Causes
+		status.Code = 400
+		status.Message = err.Error()
+		auditAnnotations := res.AuditAnnotations
+		if len(res.Errors) != 0 {
+			finalErr := errors.NewInvalid(schema.GroupKind{Group: req.Kind.Group, Kind: req.Kind.Kind}, req.Name, res.Errors)
+			status.Details.Causes = finalErr.ErrStatus.Details.Causes
+			auditAnnotations = withAnnotation(auditAnnotations, DeniedByRuleAuditKey, deniedByRules(res.Errors))
+		}
 		return &admissionv1.AdmissionResponse{
-			Allowed: false,
-			Result:  &result,
+			Allowed:          false,
+			Result:           &status,
+			Warnings:         res.Warnings,
+			AuditAnnotations: auditAnnotations,
 		}
 	}
 	ret := &admissionv1.AdmissionResponse{
-		Allowed: true,
-		Result:  &result,
+		Allowed:          true,
+		Result:           &status,
+		Warnings:         res.Warnings,
+		AuditAnnotations: res.AuditAnnotations,
 	}
-	if len(patch) != 0 {
+	if len(res.Patch) != 0 {
 		pType := admissionv1.PatchTypeJSONPatch
 		ret.PatchType = &pType
-		ret.Patch = patch
+		ret.Patch = res.Patch
 	}
 	return ret
 }
@@ -238,145 +420,233 @@ func (whsvr *webhookServer) mutate(ar *admissionv1.AdmissionReview) *admissionv1
 func (whsvr *webhookServer) createDefaultClusterRole() error {
 	_, err := whsvr.kubeClient.RbacV1().ClusterRoles().Create(context.TODO(), defaultClusterRole(), metav1.CreateOptions{})
 	if err != nil && !errors.IsAlreadyExists(err) {
+		metrics.RBACFailures.WithLabelValues("createDefaultClusterRole", "").Inc()
 		return err
 	}
 	return nil
 }
 
-func (whsvr *webhookServer) createSA(namespace string, saName string) error {
-	if saName != "" && saName != defaultServiceAccountName {
-		return nil
+// createSA creates the default service account and role binding if missing.
+// For dry-run requests nothing is created, the missing objects are returned as warnings.
+func (whsvr *webhookServer) createSA(namespace string, saName string, dryRun bool) (warnings []string, err error) {
+	defer func() {
+		if err != nil {
+			metrics.RBACFailures.WithLabelValues("createSA", namespace).Inc()
+		}
+	}()
+	defaultName := whsvr.defaults.ServiceAccountName
+	if saName != "" && saName != defaultName {
+		return nil, nil
 	}
-	_, err := whsvr.saLister.ServiceAccounts(namespace).Get(defaultServiceAccountName)
+	_, err = whsvr.saLister.ServiceAccounts(namespace).Get(defaultName)
 	if err != nil && !errors.IsNotFound(err) {
-		return err
+		return nil, err
 	}
 	if errors.IsNotFound(err) {
-		_, err = whsvr.kubeClient.CoreV1().ServiceAccounts(namespace).Create(context.TODO(), defaultServiceAccount(namespace), metav1.CreateOptions{})
-		if err != nil && !errors.IsAlreadyExists(err) {
-			return err
+		if dryRun {
+			warnings = append(warnings, fmt.Sprintf("service account %v/%v does not exist, "+
+				"it is created when the request is not dry-run", namespace, defaultName))
+		} else {
+			_, err = whsvr.kubeClient.CoreV1().ServiceAccounts(namespace).Create(context.TODO(), defaultServiceAccount(namespace, defaultName), metav1.CreateOptions{})
+			if err != nil && !errors.IsAlreadyExists(err) {
+				return nil, err
+			}
 		}
 	}
 	_, err = whsvr.roleBindingLister.RoleBindings(namespace).Get(defaultRoleBingName)
-	if err == nil && !errors.IsNotFound(err) {
-		return err
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
 	}
 	if errors.IsNotFound(err) {
-		_, err = whsvr.kubeClient.RbacV1().RoleBindings(namespace).Create(context.TODO(), defaultRoleBinding(namespace), metav1.CreateOptions{})
+		if dryRun {
+			return append(warnings, fmt.Sprintf("role binding %v/%v does not exist, "+
+				"it is created when the request is not dry-run", namespace, defaultRoleBingName)), nil
+		}
+		_, err = whsvr.kubeClient.RbacV1().RoleBindings(namespace).Create(context.TODO(), defaultRoleBinding(namespace, defaultName), metav1.CreateOptions{})
 		if err != nil && !errors.IsAlreadyExists(err) {
-			return err
+			return nil, err
 		}
 	}
-	return nil
+	return warnings, nil
 }
 
-func (whsvr *webhookServer) forSquad(req *admissionv1.AdmissionRequest) ([]byte, field.ErrorList, error) {
+func (whsvr *webhookServer) forSquad(req *admissionv1.AdmissionRequest) (*Result, error) {
 	var squad, oldSquad v1alpha1.Squad
 	if err := json.Unmarshal(req.Object.Raw, &squad); err != nil {
 		klog.Errorf("Could not unmarshal raw object: %v", err)
-		return nil, nil, err
+		return nil, err
 	}
-	if err := whsvr.createSA(req.Namespace, squad.Spec.Template.Spec.Template.Spec.ServiceAccountName); err != nil {
+	warnings, err := whsvr.createSA(req.Namespace, squad.Spec.Template.Spec.Template.Spec.ServiceAccountName, isDryRun(req))
+	if err != nil {
 		klog.Errorf("Could create service account: %v", err)
-		return nil, nil, err
+		return nil, err
 	}
 	if req.Operation == admissionv1.Create {
-		newSquad := EnsureDefaultsForSquad(&squad)
-		// validate
-		errs := ValidateSquad(newSquad)
-		if len(errs) != 0 {
-			return nil, errs, errs.ToAggregate()
-		}
-		// mutate
-		patch, err := util.CreateJsonPatch(squad, newSquad)
-		return patch, nil, err
+		warnings = append(warnings, warningsForRevisionHistoryLimit(&squad, whsvr.defaults)...)
+		newSquad, changes := EnsureDefaultsForSquad(&squad, whsvr.defaults)
+		return newMutateResult(squad, newSquad, changes, warnings)
 	}
 
 	if req.Operation == admissionv1.Update {
 		if err := json.Unmarshal(req.OldObject.Raw, &oldSquad); err != nil {
 			klog.Errorf("Could not unmarshal raw object: %v", err)
-			return nil, nil, err
-		}
-		newSquad := CopyDefaultsForSquad(&oldSquad, &squad)
-		// validate
-		errs := ValidateSquadUpdate(&oldSquad, newSquad)
-		if len(errs) != 0 {
-			return nil, errs, errs.ToAggregate()
+			return nil, err
 		}
-		patch, err := util.CreateJsonPatch(squad, newSquad)
-		return patch, nil, err
+		newSquad, changes := CopyDefaultsForSquad(&oldSquad, &squad, whsvr.defaults)
+		return newMutateResult(squad, newSquad, changes, warnings)
 	}
-	return nil, nil, nil
+	return &Result{Warnings: warnings}, nil
 }
 
-func (whsvr *webhookServer) forGameServerSet(req *admissionv1.AdmissionRequest) ([]byte, field.ErrorList, error) {
-	var gameServerSet, oldGameServerSet v1alpha1.GameServerSet
+func (whsvr *webhookServer) forGameServerSet(req *admissionv1.AdmissionRequest) (*Result, error) {
+	var gameServerSet v1alpha1.GameServerSet
 	if err := json.Unmarshal(req.Object.Raw, &gameServerSet); err != nil {
 		klog.Errorf("Could not unmarshal raw object: %v", err)
-		return nil, nil, err
+		return nil, err
 	}
-	if err := whsvr.createSA(req.Namespace,
-		gameServerSet.Spec.Template.Spec.Template.Spec.ServiceAccountName); err != nil {
+	warnings, err := whsvr.createSA(req.Namespace,
+		gameServerSet.Spec.Template.Spec.Template.Spec.ServiceAccountName, isDryRun(req))
+	if err != nil {
 		klog.Errorf("Could create service account: %v", err)
-		return nil, nil, err
+		return nil, err
 	}
 	if req.Operation == admissionv1.Create {
-		newGameServerSet := EnsureDefaultsForGameServerSet(&gameServerSet)
-		// validate
-		errs := ValidateGameServerSet(newGameServerSet)
-		if len(errs) != 0 {
-			return nil, errs, errs.ToAggregate()
-		}
-		patch, err := util.CreateJsonPatch(gameServerSet, newGameServerSet)
-		return patch, nil, err
+		newGameServerSet, changes := EnsureDefaultsForGameServerSet(&gameServerSet, whsvr.defaults)
+		return newMutateResult(gameServerSet, newGameServerSet, changes, warnings)
 	}
-	if req.Operation == admissionv1.Update {
-		if err := json.Unmarshal(req.OldObject.Raw, &oldGameServerSet); err != nil {
-			klog.Errorf("Could not unmarshal old raw object: %v", err)
-			return nil, nil, err
-		}
-		// validate
-		errs := ValidateGameServerSetUpdate(&oldGameServerSet, &gameServerSet)
-		if len(errs) != 0 {
-			return nil, errs, errs.ToAggregate()
-		}
-	}
-	return nil, nil, nil
+	return &Result{Warnings: warnings}, nil
 }
 
-func (whsvr *webhookServer) forGameServer(req *admissionv1.AdmissionRequest) ([]byte, field.ErrorList, error) {
-	var gameSvr, oldGameSvr v1alpha1.GameServer
+func (whsvr *webhookServer) forGameServer(req *admissionv1.AdmissionRequest) (*Result, error) {
+	var gameSvr v1alpha1.GameServer
 	if err := json.Unmarshal(req.Object.Raw, &gameSvr); err != nil {
 		klog.Errorf("Could not unmarshal raw object: %v", err)
-		return nil, nil, err
+		return nil, err
 	}
-	if err := whsvr.createSA(req.Namespace, gameSvr.Spec.Template.Spec.ServiceAccountName); err != nil {
+	warnings, err := whsvr.createSA(req.Namespace, gameSvr.Spec.Template.Spec.ServiceAccountName, isDryRun(req))
+	if err != nil {
 		klog.Errorf("Could create service account: %v", err)
-		return nil, nil, err
+		return nil, err
 	}
 	if req.Operation == admissionv1.Create {
-		newGameServer := EnsureDefaultForGameServer(&gameSvr)
-		// validate
-		errs := ValidateGameServer(newGameServer)
-		if len(errs) != 0 {
-			return nil, errs, errs.ToAggregate()
-		}
-		patch, err := util.CreateJsonPatch(gameSvr, newGameServer)
-		return patch, nil, err
+		newGameServer, changes := EnsureDefaultForGameServer(&gameSvr, whsvr.defaults)
+		return newMutateResult(gameSvr, newGameServer, changes, warnings)
 	}
+	return &Result{Warnings: warnings}, nil
+}
+
+// newMutateResult creates the patch from origin to the mutated object
+func newMutateResult(origin, mutated interface{}, changes *Changes, warnings []string) (*Result, error) {
+	patch, err := util.CreateJsonPatch(origin, mutated)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Patch: patch, Warnings: warnings, AuditAnnotations: changes.AuditAnnotations()}, nil
+}
+
+// isDryRun returns true if the request must not cause side effects
+func isDryRun(req *admissionv1.AdmissionRequest) bool {
+	return req.DryRun != nil && *req.DryRun
+}
 
+func (whsvr *webhookServer) validateForSquad(req *admissionv1.AdmissionRequest) (*Result, error) {
+	var squad, oldSquad v1alpha1.Squad
+	if err := json.Unmarshal(req.Object.Raw, &squad); err != nil {
+		klog.Errorf("Could not unmarshal raw object: %v", err)
+		return nil, err
+	}
+	var oldSpec *v1alpha1.GameServerSpec
 	if req.Operation == admissionv1.Update {
-		if err := json.Unmarshal(req.OldObject.Raw, &oldGameSvr); err != nil {
-			klog.Errorf("Could not unmarshal raw object: %v", err)
-			return nil, nil, err
+		if err := json.Unmarshal(req.OldObject.Raw, &oldSquad); err != nil {
+			klog.Errorf("Could not unmarshal old raw object: %v", err)
+			return nil, err
 		}
-		// validate
-		errs := ValidateGameServerUpdate(&oldGameSvr, &gameSvr)
-		if len(errs) != 0 {
-			return nil, errs, errs.ToAggregate()
+		oldSpec = &oldSquad.Spec.Template.Spec
+	}
+	// the update validations modify the objects, check the side car ports first
+	errs := whsvr.validateSideCarPorts(req, &squad.Spec.Template.Spec, oldSpec,
+		field.NewPath("spec", "template", "spec"))
+	var result *Result
+	switch req.Operation {
+	case admissionv1.Create:
+		result = ValidateSquad(&squad, whsvr.sideCarConfig())
+	case admissionv1.Update:
+		result = ValidateSquadUpdate(&oldSquad, &squad, whsvr.sideCarConfig())
+	default:
+		result = &Result{}
+	}
+	result.Errors = append(errs, result.Errors...)
+	return result, result.Errors.ToAggregate()
+}
+
+func (whsvr *webhookServer) validateForGameServerSet(req *admissionv1.AdmissionRequest) (*Result, error) {
+	var gameServerSet, oldGameServerSet v1alpha1.GameServerSet
+	if err := json.Unmarshal(req.Object.Raw, &gameServerSet); err != nil {
+		klog.Errorf("Could not unmarshal raw object: %v", err)
+		return nil, err
+	}
+	var oldSpec *v1alpha1.GameServerSpec
+	if req.Operation == admissionv1.Update {
+		if err := json.Unmarshal(req.OldObject.Raw, &oldGameServerSet); err != nil {
+			klog.Errorf("Could not unmarshal old raw object: %v", err)
+			return nil, err
 		}
+		oldSpec = &oldGameServerSet.Spec.Template.Spec
+	}
+	// the update validations modify the objects, check the side car ports first
+	errs := whsvr.validateSideCarPorts(req, &gameServerSet.Spec.Template.Spec, oldSpec,
+		field.NewPath("spec", "template", "spec"))
+	var result *Result
+	switch req.Operation {
+	case admissionv1.Create:
+		result = ValidateGameServerSet(&gameServerSet, whsvr.sideCarConfig())
+	case admissionv1.Update:
+		result = ValidateGameServerSetUpdate(&oldGameServerSet, &gameServerSet, whsvr.sideCarConfig())
+	default:
+		result = &Result{}
+	}
+	result.Errors = append(errs, result.Errors...)
+	return result, result.Errors.ToAggregate()
+}
+
+func (whsvr *webhookServer) validateForGameServer(req *admissionv1.AdmissionRequest) (*Result, error) {
+	var gameSvr, oldGameSvr v1alpha1.GameServer
+	if err := json.Unmarshal(req.Object.Raw, &gameSvr); err != nil {
+		klog.Errorf("Could not unmarshal raw object: %v", err)
+		return nil, err
 	}
-	return nil, nil, nil
+	var oldSpec *v1alpha1.GameServerSpec
+	if req.Operation == admissionv1.Update {
+		if err := json.Unmarshal(req.OldObject.Raw, &oldGameSvr); err != nil {
+			klog.Errorf("Could not unmarshal old raw object: %v", err)
+			return nil, err
+		}
+		oldSpec = &oldGameSvr.Spec
+	}
+	// the update validations modify the objects, check the side car ports first
+	var errs field.ErrorList
+	// the template of a GameServer owned by a GameServerSet is validated with its owner
+	if metav1.GetControllerOf(&gameSvr) == nil {
+		errs = whsvr.validateSideCarPorts(req, &gameSvr.Spec, oldSpec, field.NewPath("spec"))
+	}
+	var result *Result
+	switch req.Operation {
+	case admissionv1.Create:
+		result = ValidateGameServer(&gameSvr, whsvr.sideCarConfig())
+	case admissionv1.Update:
+		result = ValidateGameServerUpdate(&oldGameSvr, &gameSvr, whsvr.sideCarConfig())
+	default:
+		result = &Result{}
+	}
+	result.Errors = append(errs, result.Errors...)
+	return result, result.Errors.ToAggregate()
+}
+
+// validateSideCarPorts validates the side car ports of the pods of spec with the config of the request namespace,
+// old is the spec of the old object on update and nil on create
+func (whsvr *webhookServer) validateSideCarPorts(req *admissionv1.AdmissionRequest, spec,
+	old *v1alpha1.GameServerSpec, fldPath *field.Path) field.ErrorList {
+	return ValidateSideCarPorts(spec, old, whsvr.sideCarConfig(), whsvr.namespace(req.Namespace), fldPath)
 }
 
 func defaultClusterRole() *rbacv1.ClusterRole {
@@ -401,7 +671,7 @@ func defaultClusterRole() *rbacv1.ClusterRole {
 	}
 }
 
-func defaultRoleBinding(namespace string) *rbacv1.RoleBinding {
+func defaultRoleBinding(namespace, saName string) *rbacv1.RoleBinding {
 	return &rbacv1.RoleBinding{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      defaultRoleBingName,
@@ -415,101 +685,112 @@ func defaultRoleBinding(namespace string) *rbacv1.RoleBinding {
 		Subjects: []rbacv1.Subject{
 			{
 				Kind:      "ServiceAccount",
-				Name:      defaultServiceAccountName,
+				Name:      saName,
 				Namespace: namespace,
 			},
 		},
 	}
 }
 
-func defaultServiceAccount(namespace string) *corev1.ServiceAccount {
+func defaultServiceAccount(namespace, name string) *corev1.ServiceAccount {
 	return &corev1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      defaultServiceAccountName,
+			Name:      name,
 			Namespace: namespace,
 		},
 	}
 }
 
-func forPod(req *admissionv1.AdmissionRequest, config *SideCarConfig) ([]byte, field.ErrorList, error) {
+// forPod injects the side car into the pod of request, the config is overridden by namespace and the pod.
+func forPod(req *admissionv1.AdmissionRequest, config *SideCarConfig, namespace func(name string) *corev1.Namespace,
+	serviceAccount func(namespace, name string) *corev1.ServiceAccount) (*Result, error) {
 	var pod corev1.Pod
 	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
 		klog.Errorf("Could not unmarshal raw object: %v", err)
-		return nil, nil, err
+		return nil, err
 	}
 	if req.Operation == admissionv1.Create {
-		// validate
-		opts := []option{
-			WithImageName(config),
-			WithHealthCheck(),
-			WithEnvs(&pod),
+		// the namespace may be empty in the object, it is not patched
+		if pod.Namespace == "" {
+			pod.Namespace = req.Namespace
 		}
-		cpu, memory := getRequests(config, &pod)
-		if !cpu.IsZero() || !memory.IsZero() {
-			opts = append(opts, WithResource(config))
+		ns := namespace(pod.Namespace)
+		sa := serviceAccount(pod.Namespace, pod.Spec.ServiceAccountName)
+		podCopy, changes, warnings := MutatePod(&pod, ns, sa, config)
+		if podCopy == &pod {
+			return &Result{AuditAnnotations: changes.AuditAnnotations()}, nil
 		}
-		httpPort, grpcPort := getPorts(config, &pod)
-		addPortEnv := func(pod *corev1.Pod) {
-			for i, c := range pod.Spec.Containers {
-				if c.Name == sdkServerSidecarName {
-					continue
-				}
-				envs := []corev1.EnvVar{
-					{
-						Name:  grpcPortEnv,
-						Value: strconv.Itoa(grpcPort),
-					},
-					{
-						Name:  httpPortEnv,
-						Value: strconv.Itoa(httpPort),
-					},
-				}
-				pod.Spec.Containers[i].Env = append(pod.Spec.Containers[i].Env, envs...)
-			}
+		if errs := ValidatePodSideCarPorts(&pod, config, ns); len(errs) != 0 {
+			return &Result{Errors: errs, Warnings: warnings}, errs.ToAggregate()
 		}
-		opts = append(opts, WithArgs(httpPort, grpcPort))
-		podCopy := EnsurePod(&pod, addPortEnv, opts...)
-		patch, err := util.CreateJsonPatch(pod, podCopy)
-
-		return patch, nil, err
+		if !isDryRun(req) {
+			metrics.SidecarInjections.WithLabelValues(req.Namespace).Inc()
+		}
+		return newMutateResult(pod, podCopy, changes, warnings)
 	}
-	return nil, nil, nil
+	return nil, nil
 }
 
-func getPorts(config *SideCarConfig, pod *corev1.Pod) (int, int) {
-	httpPort, grpcPort := config.HttpPort, config.GrpcPort
-	grpcStr, ok := pod.Annotations[grpcPortKey]
-	if ok {
-		grpcPortCustom, err := strconv.Atoi(grpcStr)
-		if err == nil {
-			grpcPort = grpcPortCustom
+// MutatePod injects the side car into a game server pod. The global config is overridden by the labels
+// and annotations of namespace, which may be nil, and then the annotations of pod, the source of each
+// value is recorded in the annotation of pod. A projected token volume is added if the pod has none, unless
+// the pod or its service account, which may be nil, opts out of the token.
+// The pod is returned as is if not mutated, e.g. the injection is disabled, and the reason is recorded in
+// the changes. The warnings are the invalid overrides, the adjusted resources and the missing token.
+func MutatePod(pod *corev1.Pod, namespace *corev1.Namespace, serviceAccount *corev1.ServiceAccount,
+	global *SideCarConfig) (*corev1.Pod, *Changes, []string) {
+	config, sources, warnings := ResolveSideCarConfig(global, namespace, pod)
+	if config.InjectionDisabled {
+		changes := &Changes{}
+		if gameServerPod(pod) && !sideCarExist(pod) {
+			changes.SideCarSkipped = injectionSkipReason(sources, namespace)
 		}
+		return pod, changes, nil
 	}
-	httpStr, ok := pod.Annotations[httpPortKey]
-	if ok {
-		httpPortCustom, err := strconv.Atoi(httpStr)
-		if err == nil {
-			httpPort = httpPortCustom
-		}
+	opts := []option{
+		WithImageName(config),
+		WithEnvs(pod, append(append([]corev1.EnvVar{}, config.Env...), config.ExtraEnv...)...),
 	}
-	return httpPort, grpcPort
-}
-
-func getRequests(config *SideCarConfig, pod *corev1.Pod) (resource.Quantity, resource.Quantity) {
-	cpu, memory := config.CPU, config.Memory
-	cpuStr, ok := pod.Annotations[cpuKey]
-	if ok {
-		cpuCustom, err := resource.ParseQuantity(cpuStr)
-		if err == nil {
-			cpu = cpuCustom
-		}
+	resources, resourceWarnings := SideCarResources(pod, config)
+	warnings = append(warnings, resourceWarnings...)
+	if len(resources.Requests) != 0 || len(resources.Limits) != 0 {
+		opts = append(opts, WithResource(resources))
 	}
-	memoryStr, ok := pod.Annotations[memoryKey]
-	if ok {
-		memoryCustom, err := resource.ParseQuantity(memoryStr)
-		if err == nil {
-			memory = memoryCustom
+	httpPort, grpcPort := config.HttpPort, config.GrpcPort
+	opts = append(opts, WithHealthCheck(config.Probes, httpPort))
+	var tokenWarning string
+	mutatePod := func(pod *corev1.Pod) {
+		for i, c := range pod.Spec.Containers {
+			if c.Name == sdkServerSidecarName {
+				continue
+			}
+			envs := []corev1.EnvVar{
+				{
+					Name:  grpcPortEnv,
+					Value: strconv.Itoa(grpcPort),
+				},
+				{
+					Name:  httpPortEnv,
+					Value: strconv.Itoa(httpPort),
+				},
+			}
+			pod.Spec.Containers[i].Env = append(pod.Spec.Containers[i].Env, envs...)
+		}
+		tokenWarning = ensureTokenVolume(pod, serviceAccount, config.Token)
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
 		}
+		pod.Annotations[SideCarConfigSourceKey] = sources.String()
+	}
+	args := append(append([]string{}, config.Args...), config.ExtraArgs...)
+	opts = append(opts, WithArgs(httpPort, grpcPort, config.LogLevel, args...),
+		WithTemplate(config.Template, pod, httpPort, grpcPort))
+	podCopy, changes := EnsurePod(pod, mutatePod, opts...)
+	if podCopy == pod {
+		return pod, changes, nil
+	}
+	if tokenWarning != "" {
+		warnings = append(warnings, tokenWarning)
 	}
-	return cpu, memory
+	return podCopy, changes, warnings
 }
@@ -0,0 +1,272 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	rbaclisterv1 "k8s.io/client-go/listers/rbac/v1"
+
+	"github.com/ocgi/carrier-webhook/pkg/metrics"
+	carrierv1alpha1 "github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
+)
+
+func newTestWebhookServer() *webhookServer {
+	client := fake.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(client, 0)
+	whsvr, err := NewWebhookServer(&SideCarConfig{}, NewDefaults(), client, factory)
+	if err != nil {
+		panic(err)
+	}
+	return whsvr
+}
+
+func doReview(t *testing.T, whsvr *webhookServer, path string, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	ar := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionv1.SchemeGroupVersion.String(),
+			Kind:       "AdmissionReview",
+		},
+		Request: req,
+	}
+	body, err := json.Marshal(ar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	whsvr.Serve(w, r)
+
+	var out admissionv1.AdmissionReview
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+	if out.Response == nil {
+		t.Fatalf("empty response for %v", path)
+	}
+	return out.Response
+}
+
+func squadRequest(t *testing.T, squad *carrierv1alpha1.Squad) *admissionv1.AdmissionRequest {
+	raw, err := json.Marshal(squad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &admissionv1.AdmissionRequest{
+		UID:       "test",
+		Kind:      metav1.GroupVersionKind{Group: carrierv1alpha1.SchemeGroupVersion.Group, Version: "v1alpha1", Kind: "Squad"},
+		Name:      squad.Name,
+		Namespace: "default",
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func TestMutateAndValidateSquad(t *testing.T) {
+	whsvr := newTestWebhookServer()
+	// the default squad has no container named server, it is invalid
+	req := squadRequest(t, defaultSquad())
+
+	resp := doReview(t, whsvr, "/mutate", req)
+	if !resp.Allowed {
+		t.Errorf("mutate should not deny, got %v", resp.Result)
+	}
+	if len(resp.Patch) == 0 {
+		t.Errorf("mutate should return defaults patch")
+	}
+	if resp.UID != req.UID {
+		t.Errorf("desired uid %v, got %v", req.UID, resp.UID)
+	}
+	if !strings.Contains(resp.AuditAnnotations[DefaultedFieldsAuditKey], "spec.scheduling") {
+		t.Errorf("desired defaulted fields in audit annotations, got %v", resp.AuditAnnotations)
+	}
+
+	resp = doReview(t, whsvr, "/validate", req)
+	if resp.Allowed {
+		t.Errorf("validate should deny squad without server container")
+	}
+	if len(resp.Patch) != 0 {
+		t.Errorf("validate should never patch, got %s", resp.Patch)
+	}
+	if resp.Result == nil || resp.Result.Details == nil || len(resp.Result.Details.Causes) == 0 {
+		t.Errorf("validate should report causes, got %v", resp.Result)
+	}
+	if got := resp.AuditAnnotations[DeniedByRuleAuditKey]; !strings.Contains(got,
+		"spec.template.spec.containers:FieldValueInvalid") {
+		t.Errorf("desired denied rules in audit annotations, got %v", resp.AuditAnnotations)
+	}
+}
+
+func TestServeReviewVersions(t *testing.T) {
+	whsvr := newTestWebhookServer()
+	req := squadRequest(t, defaultSquad())
+	raw, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name       string
+		apiVersion string
+		code       int
+	}{
+		{name: "v1", apiVersion: "admission.k8s.io/v1", code: http.StatusOK},
+		{name: "v1beta1", apiVersion: "admission.k8s.io/v1beta1", code: http.StatusOK},
+		{name: "unknown", apiVersion: "admission.k8s.io/v2", code: http.StatusBadRequest},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			body := []byte(`{"apiVersion":"` + c.apiVersion + `","kind":"AdmissionReview","request":` + string(raw) + `}`)
+			r := httptest.NewRequest(http.MethodPost, MutatePath, bytes.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			whsvr.Serve(w, r)
+			if w.Code != c.code {
+				t.Fatalf("desired code %v, got %v: %s", c.code, w.Code, w.Body.String())
+			}
+			if c.code != http.StatusOK {
+				if !bytes.Contains(w.Body.Bytes(), []byte("unsupported AdmissionReview version")) {
+					t.Errorf("desired unsupported version error, got %s", w.Body.String())
+				}
+				return
+			}
+			var out admissionv1.AdmissionReview
+			if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.APIVersion != c.apiVersion || out.Kind != "AdmissionReview" {
+				t.Errorf("desired %v AdmissionReview, got %v %v", c.apiVersion, out.APIVersion, out.Kind)
+			}
+			if out.Response == nil || out.Response.UID != req.UID || !out.Response.Allowed || len(out.Response.Patch) == 0 {
+				t.Errorf("unexpected response %+v", out.Response)
+			}
+		})
+	}
+}
+
+func TestMutateDryRun(t *testing.T) {
+	for _, dryRun := range []bool{true, false} {
+		whsvr := newTestWebhookServer()
+		req := squadRequest(t, defaultSquad())
+		req.DryRun = &dryRun
+
+		resp := doReview(t, whsvr, MutatePath, req)
+		if !resp.Allowed || len(resp.Patch) == 0 {
+			t.Errorf("dry-run %v: defaults patch should be returned, got %+v", dryRun, resp)
+		}
+		sas, err := whsvr.kubeClient.CoreV1().ServiceAccounts("default").List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rbs, err := whsvr.kubeClient.RbacV1().RoleBindings("default").List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dryRun {
+			if len(sas.Items) != 0 || len(rbs.Items) != 0 {
+				t.Errorf("dry-run should not create objects, got %v service accounts and %v role bindings",
+					len(sas.Items), len(rbs.Items))
+			}
+			if len(resp.Warnings) != 2 {
+				t.Errorf("dry-run should warn of missing objects, got %v", resp.Warnings)
+			}
+			continue
+		}
+		if len(sas.Items) != 1 || len(rbs.Items) != 1 {
+			t.Errorf("service account and role binding should be created, got %v and %v",
+				len(sas.Items), len(rbs.Items))
+		}
+		if len(resp.Warnings) != 0 {
+			t.Errorf("no warnings desired, got %v", resp.Warnings)
+		}
+	}
+}
+
+type failingRoleBindingLister struct {
+	rbaclisterv1.RoleBindingLister
+}
+
+func (failingRoleBindingLister) RoleBindings(string) rbaclisterv1.RoleBindingNamespaceLister {
+	return failingRoleBindingNamespaceLister{}
+}
+
+type failingRoleBindingNamespaceLister struct {
+	rbaclisterv1.RoleBindingNamespaceLister
+}
+
+func (failingRoleBindingNamespaceLister) Get(string) (*rbacv1.RoleBinding, error) {
+	return nil, fmt.Errorf("lister failure")
+}
+
+func TestCreateSARoleBindingListerError(t *testing.T) {
+	whsvr := newTestWebhookServer()
+	whsvr.roleBindingLister = failingRoleBindingLister{}
+	counter := metrics.RBACFailures.WithLabelValues("createSA", "lister-error")
+	before := testutil.ToFloat64(counter)
+
+	for _, dryRun := range []bool{true, false} {
+		warnings, err := whsvr.createSA("lister-error", "", dryRun)
+		if err == nil {
+			t.Errorf("dry-run %v: lister error should be returned, got warnings %v", dryRun, warnings)
+		}
+	}
+	if got := testutil.ToFloat64(counter) - before; got != 2 {
+		t.Errorf("2 rbac failures desired, got %v", got)
+	}
+}
+
+func TestReadinessChecks(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(client, 0)
+	whsvr, err := NewWebhookServer(&SideCarConfig{}, NewDefaults(), client, factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	if err := whsvr.CheckInformerSync(r); err == nil {
+		t.Errorf("informers are not started, the check should fail")
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	factory.Start(stop)
+	factory.WaitForCacheSync(stop)
+	if err := whsvr.CheckClusterRole(r); err == nil {
+		t.Errorf("cluster role is not created, the check should fail")
+	}
+	if err := whsvr.createDefaultClusterRole(); err != nil {
+		t.Fatal(err)
+	}
+	err = wait.PollImmediate(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return whsvr.CheckClusterRole(r) == nil, nil
+	})
+	if err != nil {
+		t.Errorf("cluster role is created, got %v", whsvr.CheckClusterRole(r))
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	configv1alpha1 "github.com/ocgi/carrier-webhook/pkg/apis/config/v1alpha1"
+)
+
+// InjectKey is the label or annotation enabling or disabling the side car injection, "true" or "false"
+const InjectKey = "carrier.ocgi.dev/inject"
+
+// InjectionPolicy decides the side car injection of pods without InjectKey, the policies are declared
+// with the configuration file
+type InjectionPolicy = configv1alpha1.InjectionPolicy
+
+const (
+	// InjectionOptOut injects the side car unless disabled by InjectKey
+	InjectionOptOut = configv1alpha1.InjectionOptOut
+	// InjectionOptIn injects the side car only if enabled by InjectKey
+	InjectionOptIn = configv1alpha1.InjectionOptIn
+)
+
+// InjectionPolicies are the supported policies
+var InjectionPolicies = configv1alpha1.InjectionPolicies
+
+func parseInject(value string, disabled *bool) error {
+	switch value {
+	case "true":
+		*disabled = false
+	case "false":
+		*disabled = true
+	default:
+		return fmt.Errorf("is neither true nor false")
+	}
+	return nil
+}
+
+// injectionSkipReason describes why the side car is not injected by the layer config is resolved from
+func injectionSkipReason(sources SideCarSources, namespace *corev1.Namespace) string {
+	switch sources["inject"] {
+	case SourcePod:
+		return fmt.Sprintf("disabled by %v of pod", InjectKey)
+	case SourceNamespace:
+		return fmt.Sprintf("disabled by %v of namespace %v", InjectKey, namespace.Name)
+	default:
+		return fmt.Sprintf("not enabled by %v in %v mode", InjectKey, InjectionOptIn)
+	}
+}
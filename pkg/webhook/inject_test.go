@@ -0,0 +1,101 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMutatePodInjection(t *testing.T) {
+	cases := []struct {
+		name     string
+		optIn    bool
+		labels   map[string]string
+		nsAnnos  map[string]string
+		podAnnos map[string]string
+		sideCar  bool
+		injected bool
+		skipped  string
+		warnings int
+	}{
+		{
+			name:     "injected by default",
+			injected: true,
+		},
+		{
+			name:     "disabled by pod",
+			podAnnos: map[string]string{InjectKey: "false"},
+			skipped:  "disabled by carrier.ocgi.dev/inject of pod",
+		},
+		{
+			name:    "disabled by namespace label",
+			labels:  map[string]string{InjectKey: "false"},
+			skipped: "disabled by carrier.ocgi.dev/inject of namespace game",
+		},
+		{
+			name:     "pod overrides namespace",
+			nsAnnos:  map[string]string{InjectKey: "false"},
+			podAnnos: map[string]string{InjectKey: "true"},
+			injected: true,
+		},
+		{
+			name:    "not enabled in opt-in mode",
+			optIn:   true,
+			skipped: "not enabled by carrier.ocgi.dev/inject in OptIn mode",
+		},
+		{
+			name:     "enabled by namespace in opt-in mode",
+			optIn:    true,
+			nsAnnos:  map[string]string{InjectKey: "true"},
+			injected: true,
+		},
+		{
+			name:     "invalid value",
+			podAnnos: map[string]string{InjectKey: "no"},
+			injected: true,
+			warnings: 1,
+		},
+		{
+			name:    "side car exists",
+			sideCar: true,
+			skipped: "side car exists",
+		},
+	}
+	for _, c := range cases {
+		config := testGlobalConfig()
+		config.InjectionDisabled = c.optIn
+		namespace := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "game", Labels: c.labels, Annotations: c.nsAnnos},
+		}
+		pod := defaultTestPod().Obj()
+		pod.Annotations = c.podAnnos
+		if c.sideCar {
+			pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: sdkServerSidecarName})
+		}
+		mutated, changes, warnings := MutatePod(pod, namespace, nil, config)
+		if injected := mutated != pod; injected != c.injected {
+			t.Errorf("%v: desired injected %v, got %v", c.name, c.injected, injected)
+		}
+		if got := changes.AuditAnnotations()[SideCarSkippedAuditKey]; got != c.skipped {
+			t.Errorf("%v: desired skip reason %q, got %q", c.name, c.skipped, got)
+		}
+		if len(warnings) != c.warnings {
+			t.Errorf("%v: desired %v warnings, got %v", c.name, c.warnings, warnings)
+		}
+	}
+}
@@ -15,20 +15,52 @@
 package webhook
 
 import (
-	"strings"
-
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 
 	"github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
 	carrierutil "github.com/ocgi/carrier/pkg/util"
 )
 
+// Defaults describes the values set by the mutating webhook
+type Defaults struct {
+	// ServiceAccountName is the service account of game server pods, created if not exists
+	ServiceAccountName string
+	// Scheduling is the default scheduling strategy
+	Scheduling v1alpha1.SchedulingStrategy
+	// PortPolicy is the default port policy of ports without host port
+	PortPolicy v1alpha1.PortPolicy
+	// RevisionHistoryLimit is the revision history limit of Squad
+	RevisionHistoryLimit int32
+	// MaxSurge is the default max surge of Squad rolling update
+	MaxSurge intstr.IntOrString
+	// MaxUnavailable is the default max unavailable of Squad rolling update
+	MaxUnavailable intstr.IntOrString
+}
+
+// NewDefaults returns the built-in defaults
+func NewDefaults() *Defaults {
+	return &Defaults{
+		ServiceAccountName:   defaultServiceAccountName,
+		Scheduling:           v1alpha1.MostAllocated,
+		PortPolicy:           v1alpha1.LoadBalancer,
+		RevisionHistoryLimit: 10,
+		MaxSurge:             intstr.FromString("25%"),
+		MaxUnavailable:       intstr.FromString("25%"),
+	}
+}
+
 // EnsurePod add side car to the pod and create patch.
-func EnsurePod(pod *corev1.Pod, f func(*corev1.Pod), opts ...option) *corev1.Pod {
-	if sideCarExist(pod) || !gameServerPod(pod) {
-		return pod
+func EnsurePod(pod *corev1.Pod, f func(*corev1.Pod), opts ...option) (*corev1.Pod, *Changes) {
+	changes := &Changes{}
+	if !gameServerPod(pod) {
+		return pod, changes
+	}
+	if sideCarExist(pod) {
+		changes.SideCarSkipped = "side car exists"
+		return pod, changes
 	}
 	podCopy := pod.DeepCopy()
 	// add pod to containers
@@ -40,46 +72,56 @@ func EnsurePod(pod *corev1.Pod, f func(*corev1.Pod), opts ...option) *corev1.Pod
 		opt(sideCar)
 	}
 	podCopy.Spec.Containers = append(podCopy.Spec.Containers, *sideCar)
-	return podCopy
+	changes.SideCarImage = sideCar.Image
+	return podCopy, changes
 }
 
 // EnsureDefaultForGameServer ensure some default fields of GameServer
-func EnsureDefaultForGameServer(gs *v1alpha1.GameServer) *v1alpha1.GameServer {
+func EnsureDefaultForGameServer(gs *v1alpha1.GameServer, d *Defaults) (*v1alpha1.GameServer, *Changes) {
+	changes := &Changes{}
+	specPath := field.NewPath("spec")
 	gsCopy := gs.DeepCopy()
-	ensureLBReadinessGates(gsCopy)
-	ensureDefaultSchedulingPolicy(&gsCopy.Spec.Scheduling)
-	ensureDefaultServiceAccount(&gsCopy.Spec)
-	ensureDefaultPortType(&gsCopy.Spec)
-	return gsCopy
+	ensureLBReadinessGates(gsCopy, changes)
+	ensureDefaultSchedulingPolicy(d, &gsCopy.Spec.Scheduling, specPath.Child("scheduling"), changes)
+	ensureDefaultServiceAccount(d, &gsCopy.Spec, specPath, changes)
+	ensureDefaultPortType(d, &gsCopy.Spec, specPath, changes)
+	return gsCopy, changes
 }
 
 // EnsureDefaultsForGameServerSet ensure some default fields of GameServerSet
-func EnsureDefaultsForGameServerSet(gsSet *v1alpha1.GameServerSet) *v1alpha1.GameServerSet {
+func EnsureDefaultsForGameServerSet(gsSet *v1alpha1.GameServerSet, d *Defaults) (*v1alpha1.GameServerSet, *Changes) {
+	changes := &Changes{}
+	specPath := field.NewPath("spec")
 	gsSetCopy := gsSet.DeepCopy()
-	ensureDefaultTemplateLabel(&gsSetCopy.Spec.Template, carrierutil.GameServerSetLabelKey, gsSetCopy.Name)
+	ensureDefaultTemplateLabel(&gsSetCopy.Spec.Template, carrierutil.GameServerSetLabelKey, gsSetCopy.Name,
+		specPath.Child("template"), changes)
 	if gsSetCopy.Spec.Selector == nil {
 		gsSetCopy.Spec.Selector = &metav1.LabelSelector{}
 	}
-	ensureDefaultSelector(gsSetCopy.Spec.Selector, carrierutil.GameServerSetLabelKey, gsSetCopy.Name)
-	ensureDefaultSchedulingPolicy(&gsSetCopy.Spec.Scheduling)
-	ensureDefaultServiceAccount(&gsSetCopy.Spec.Template.Spec)
-	ensureDefaultPortType(&gsSetCopy.Spec.Template.Spec)
-	return gsSetCopy
+	ensureDefaultSelector(gsSetCopy.Spec.Selector, carrierutil.GameServerSetLabelKey, gsSetCopy.Name,
+		specPath.Child("selector"), changes)
+	ensureDefaultSchedulingPolicy(d, &gsSetCopy.Spec.Scheduling, specPath.Child("scheduling"), changes)
+	ensureDefaultServiceAccount(d, &gsSetCopy.Spec.Template.Spec, specPath.Child("template", "spec"), changes)
+	ensureDefaultPortType(d, &gsSetCopy.Spec.Template.Spec, specPath.Child("template", "spec"), changes)
+	return gsSetCopy, changes
 }
 
 // EnsureDefaultsForSquad ensure some default fields of Squad
-func EnsureDefaultsForSquad(squad *v1alpha1.Squad) *v1alpha1.Squad {
+func EnsureDefaultsForSquad(squad *v1alpha1.Squad, d *Defaults) (*v1alpha1.Squad, *Changes) {
+	changes := &Changes{}
+	specPath := field.NewPath("spec")
 	squadCopy := squad.DeepCopy()
-	ensureDefaultRevisionHistoryLimit(&squadCopy.Spec)
-	ensureDefaultStrategy(&squadCopy.Spec.Strategy)
+	ensureDefaultRevisionHistoryLimit(d, &squadCopy.Spec, specPath.Child("revisionHistoryLimit"), changes)
+	ensureDefaultStrategy(d, &squadCopy.Spec.Strategy, specPath.Child("strategy"), changes)
 	if squadCopy.Spec.Selector == nil {
 		squadCopy.Spec.Selector = &metav1.LabelSelector{}
 	}
-	ensureDefaultSelector(squadCopy.Spec.Selector, carrierutil.SquadNameLabelKey, squadCopy.Name)
-	ensureDefaultSchedulingPolicy(&squadCopy.Spec.Scheduling)
-	ensureDefaultServiceAccount(&squadCopy.Spec.Template.Spec)
-	ensureDefaultPortType(&squadCopy.Spec.Template.Spec)
-	return squadCopy
+	ensureDefaultSelector(squadCopy.Spec.Selector, carrierutil.SquadNameLabelKey, squadCopy.Name,
+		specPath.Child("selector"), changes)
+	ensureDefaultSchedulingPolicy(d, &squadCopy.Spec.Scheduling, specPath.Child("scheduling"), changes)
+	ensureDefaultServiceAccount(d, &squadCopy.Spec.Template.Spec, specPath.Child("template", "spec"), changes)
+	ensureDefaultPortType(d, &squadCopy.Spec.Template.Spec, specPath.Child("template", "spec"), changes)
+	return squadCopy, changes
 }
 
 // sideCarExist checks if side car already exist
@@ -111,13 +153,7 @@ func defaultSideCar(pod *corev1.Pod) *corev1.Container {
 
 // buildSideCarVolumeMount build volume mount of service account.
 func buildSideCarVolumeMount(pod *corev1.Pod) []corev1.VolumeMount {
-	volumeName := ""
-	for _, v := range pod.Spec.Volumes {
-		if strings.Contains(v.Name, pod.Spec.ServiceAccountName+"-token") {
-			volumeName = v.Name
-			break
-		}
-	}
+	volumeName := serviceAccountTokenVolume(pod)
 	if volumeName == "" {
 		return nil
 	}
@@ -131,7 +167,7 @@ func buildSideCarVolumeMount(pod *corev1.Pod) []corev1.VolumeMount {
 }
 
 // ensureLBReadinessGates LB readinessGates if using LB
-func ensureLBReadinessGates(gs *v1alpha1.GameServer) {
+func ensureLBReadinessGates(gs *v1alpha1.GameServer, changes *Changes) {
 	if len(gs.Annotations) == 0 {
 		return
 	}
@@ -144,53 +180,63 @@ func ensureLBReadinessGates(gs *v1alpha1.GameServer) {
 		}
 	}
 	gs.Spec.ReadinessGates = append(gs.Spec.ReadinessGates, LBReadyKey)
+	changes.defaulted(field.NewPath("spec", "readinessGates"))
 }
 
 // ensureDefaultPortType ensure default policyType of GameServer: LoaderBalancer
-func ensureDefaultPortType(gsSpec *v1alpha1.GameServerSpec) {
+func ensureDefaultPortType(d *Defaults, gsSpec *v1alpha1.GameServerSpec, fldPath *field.Path, changes *Changes) {
 	for i, port := range gsSpec.Ports {
 		if len(port.PortPolicy) == 0 && port.HostPort == nil && port.HostPortRange == nil {
-			gsSpec.Ports[i].PortPolicy = v1alpha1.LoadBalancer
+			gsSpec.Ports[i].PortPolicy = d.PortPolicy
+			changes.defaulted(fldPath.Child("ports").Index(i).Child("portPolicy"))
 		}
 	}
 }
 
 // ensureDefaultServiceAccount ensure default serviceAccount name
-func ensureDefaultServiceAccount(gsSpec *v1alpha1.GameServerSpec) {
+func ensureDefaultServiceAccount(d *Defaults, gsSpec *v1alpha1.GameServerSpec, fldPath *field.Path,
+	changes *Changes) {
 	if gsSpec.Template.Spec.ServiceAccountName == "" {
-		gsSpec.Template.Spec.ServiceAccountName = defaultServiceAccountName
+		gsSpec.Template.Spec.ServiceAccountName = d.ServiceAccountName
+		changes.defaulted(fldPath.Child("template", "spec", "serviceAccountName"))
 	}
 }
 
 // ensureDefaultServiceAccount ensure default scheduling strategy
-func ensureDefaultSchedulingPolicy(strategy *v1alpha1.SchedulingStrategy) {
+func ensureDefaultSchedulingPolicy(d *Defaults, strategy *v1alpha1.SchedulingStrategy, fldPath *field.Path,
+	changes *Changes) {
 	// setting scheduling strategy
 	if *strategy == "" {
-		*strategy = v1alpha1.MostAllocated
+		*strategy = d.Scheduling
+		changes.defaulted(fldPath)
 	}
 }
 
 // ensureDefaultSelector ensure default label selector
-func ensureDefaultSelector(selector *metav1.LabelSelector, kind, name string) {
+func ensureDefaultSelector(selector *metav1.LabelSelector, kind, name string, fldPath *field.Path, changes *Changes) {
 	// setting selector
 	if selector.MatchLabels == nil {
 		selector.MatchLabels = map[string]string{
 			kind: name,
 		}
+		changes.defaulted(fldPath.Child("matchLabels"))
 	}
 }
 
 // ensureDefaultTemplateLabel ensure default label
-func ensureDefaultTemplateLabel(gameServerTemplate *v1alpha1.GameServerTemplateSpec, kind, name string) {
+func ensureDefaultTemplateLabel(gameServerTemplate *v1alpha1.GameServerTemplateSpec, kind, name string,
+	fldPath *field.Path, changes *Changes) {
 	if len(gameServerTemplate.Labels) == 0 {
 		gameServerTemplate.Labels = map[string]string{kind: name}
+		changes.defaulted(fldPath.Child("metadata", "labels"))
 	}
 }
 
 // ensureDefaultStrategy ensure default update policy.
-func ensureDefaultStrategy(strategy *v1alpha1.SquadStrategy) {
+func ensureDefaultStrategy(d *Defaults, strategy *v1alpha1.SquadStrategy, fldPath *field.Path, changes *Changes) {
 	if strategy.Type == "" {
 		strategy.Type = v1alpha1.RollingUpdateSquadStrategyType
+		changes.defaulted(fldPath.Child("type"))
 	}
 	if strategy.Type == v1alpha1.RollingUpdateSquadStrategyType {
 		if strategy.RollingUpdate == nil {
@@ -199,31 +245,41 @@ func ensureDefaultStrategy(strategy *v1alpha1.SquadStrategy) {
 		}
 		if strategy.RollingUpdate.MaxUnavailable == nil {
 			// Set default MaxUnavailable as 25% by default.
-			maxUnavailable := intstr.FromString("25%")
+			maxUnavailable := d.MaxUnavailable
 			strategy.RollingUpdate.MaxUnavailable = &maxUnavailable
+			changes.defaulted(fldPath.Child("rollingUpdate", "maxUnavailable"))
 		}
 		if strategy.RollingUpdate.MaxSurge == nil {
 			// Set default MaxSurge as 25% by default.
-			maxSurge := intstr.FromString("25%")
+			maxSurge := d.MaxSurge
 			strategy.RollingUpdate.MaxSurge = &maxSurge
+			changes.defaulted(fldPath.Child("rollingUpdate", "maxSurge"))
 		}
 	}
 }
 
-// ensureDefaultRevisionHistoryLimit revisionHistoryLimit to 10.
-func ensureDefaultRevisionHistoryLimit(squadSpec *v1alpha1.SquadSpec) {
+// ensureDefaultRevisionHistoryLimit revisionHistoryLimit to 10 by default.
+func ensureDefaultRevisionHistoryLimit(d *Defaults, squadSpec *v1alpha1.SquadSpec, fldPath *field.Path,
+	changes *Changes) {
 	if squadSpec.RevisionHistoryLimit == nil {
 		squadSpec.RevisionHistoryLimit = new(int32)
+	} else if *squadSpec.RevisionHistoryLimit == d.RevisionHistoryLimit {
+		return
 	}
-	*squadSpec.RevisionHistoryLimit = 10
+	*squadSpec.RevisionHistoryLimit = d.RevisionHistoryLimit
+	changes.defaulted(fldPath)
 }
 
 // CopyDefaultsForSquad copy some default fields of Squad
-func CopyDefaultsForSquad(oldSquad, newSquad *v1alpha1.Squad) *v1alpha1.Squad {
+func CopyDefaultsForSquad(oldSquad, newSquad *v1alpha1.Squad, d *Defaults) (*v1alpha1.Squad, *Changes) {
+	changes := &Changes{}
+	specPath := field.NewPath("spec", "template", "spec")
 	squadCopy := newSquad.DeepCopy()
-	if squadCopy.Spec.Template.Spec.Template.Spec.ServiceAccountName == "" {
-		squadCopy.Spec.Template.Spec.Template.Spec.ServiceAccountName = oldSquad.Spec.Template.Spec.Template.Spec.ServiceAccountName
+	oldServiceAccountName := oldSquad.Spec.Template.Spec.Template.Spec.ServiceAccountName
+	if squadCopy.Spec.Template.Spec.Template.Spec.ServiceAccountName == "" && oldServiceAccountName != "" {
+		squadCopy.Spec.Template.Spec.Template.Spec.ServiceAccountName = oldServiceAccountName
+		changes.defaulted(specPath.Child("template", "spec", "serviceAccountName"))
 	}
-	ensureDefaultPortType(&squadCopy.Spec.Template.Spec)
-	return squadCopy
+	ensureDefaultPortType(d, &squadCopy.Spec.Template.Spec, specPath, changes)
+	return squadCopy, changes
 }
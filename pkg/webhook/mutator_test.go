@@ -55,27 +55,50 @@ func TestEnsurePod(t *testing.T) {
 			pod:    defaultTestPod().Obj(),
 			newPod: healthOption().Obj(),
 			opts: []option{
-				WithHealthCheck(),
+				WithHealthCheck(DefaultSideCarProbes(), 9021),
 			},
 		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			pod := EnsurePod(tc.pod, nil, tc.opts...)
+			pod, _ := EnsurePod(tc.pod, nil, tc.opts...)
 			if !reflect.DeepEqual(pod, tc.newPod) {
 				t.Errorf("old: %v", tc.pod.Spec.Containers)
 				t.Errorf("\ndesired:\n%v\nactual:\n%v", tc.newPod.Spec.Containers, pod.Spec.Containers)
 			}
 		})
 	}
+
+	_, changes := EnsurePod(defaultTestPod().Obj(), nil, WithImageName(&SideCarConfig{Image: "sdk:v1"}))
+	if got := changes.AuditAnnotations()[SideCarImageAuditKey]; got != "sdk:v1" {
+		t.Errorf("desired side car image audit annotation sdk:v1, got %v", got)
+	}
 }
 
 func TestEnsureSquad(t *testing.T) {
-	actual := EnsureDefaultsForSquad(defaultSquad())
+	actual, changes := EnsureDefaultsForSquad(defaultSquad(), NewDefaults())
 	desired := filledSquad()
 	if !reflect.DeepEqual(actual, desired) {
 		t.Errorf("\ndesired:\n%v\nactual:\n%v", desired, actual)
 	}
+	desiredFields := []string{
+		"spec.revisionHistoryLimit",
+		"spec.strategy.type",
+		"spec.strategy.rollingUpdate.maxUnavailable",
+		"spec.strategy.rollingUpdate.maxSurge",
+		"spec.selector.matchLabels",
+		"spec.scheduling",
+		"spec.template.spec.template.spec.serviceAccountName",
+		"spec.template.spec.ports[0].portPolicy",
+	}
+	if !reflect.DeepEqual(changes.DefaultedFields, desiredFields) {
+		t.Errorf("desired defaulted fields %v, got %v", desiredFields, changes.DefaultedFields)
+	}
+
+	// nothing changed for the filled squad
+	if _, changes := EnsureDefaultsForSquad(filledSquad(), NewDefaults()); changes.AuditAnnotations() != nil {
+		t.Errorf("desired no audit annotations, got %v", changes.AuditAnnotations())
+	}
 }
 
 // addSidecar add side car to test pod
@@ -97,10 +120,10 @@ func addSidecar(pw *k8testing.PodWrapper) *k8testing.PodWrapper {
 // addHealthCheck add heal check.
 func addHealthCheck(pw *k8testing.PodWrapper) *k8testing.PodWrapper {
 	livenessProbe := &v1.Probe{
-		Handler: v1.Handler{
+		ProbeHandler: v1.ProbeHandler{
 			HTTPGet: &v1.HTTPGetAction{
 				Path: "/healthz",
-				Port: intstr.FromInt(8080),
+				Port: intstr.FromInt(9021),
 			},
 		},
 		InitialDelaySeconds: 3,
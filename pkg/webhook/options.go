@@ -18,24 +18,15 @@ import (
 	"fmt"
 
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
-	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // option defines func for sidecar to inject
 type option func(*corev1.Container)
 
-// WithResource add resource to sidecar
-func WithResource(sc *SideCarConfig) option {
+// WithResource add resources to sidecar, see SideCarResources
+func WithResource(resources corev1.ResourceRequirements) option {
 	return func(container *corev1.Container) {
-		resource := map[corev1.ResourceName]resource.Quantity{
-			corev1.ResourceCPU:    sc.CPU,
-			corev1.ResourceMemory: sc.Memory,
-		}
-		container.Resources = corev1.ResourceRequirements{
-			Limits:   resource,
-			Requests: resource,
-		}
+		container.Resources = resources
 	}
 }
 
@@ -46,28 +37,9 @@ func WithImageName(sc *SideCarConfig) option {
 	}
 }
 
-// WithHealthCheck inject health check
-func WithHealthCheck() option {
-	return func(container *corev1.Container) {
-		livenessProbe := &corev1.Probe{
-			ProbeHandler: corev1.ProbeHandler{
-				HTTPGet: &corev1.HTTPGetAction{
-					Path: "/healthz",
-					Port: intstr.FromInt(8080),
-				},
-			},
-			InitialDelaySeconds: 3,
-			TimeoutSeconds:      1,
-			PeriodSeconds:       10,
-			SuccessThreshold:    1,
-			FailureThreshold:    3,
-		}
-		container.LivenessProbe = livenessProbe
-	}
-}
-
-// WithEnvs add port envs to side car
-func WithEnvs(pod *corev1.Pod) option {
+// WithEnvs add the name and namespace of pod and the extra env to side car, a later env replaces
+// the earlier one with the same name
+func WithEnvs(pod *corev1.Pod, env ...corev1.EnvVar) option {
 	return func(container *corev1.Container) {
 		container.Env = []corev1.EnvVar{
 			{
@@ -83,16 +55,20 @@ func WithEnvs(pod *corev1.Pod) option {
 				},
 			},
 		}
+		for _, e := range env {
+			container.Env = mergeEnv(container.Env, e)
+		}
 	}
 }
 
-// WithArgs add port to side car args
-func WithArgs(httpPort, grpcPort int) option {
+// WithArgs add port, log level and extra args to side car args
+func WithArgs(httpPort, grpcPort, logLevel int, extraArgs ...string) option {
 	return func(container *corev1.Container) {
 		container.Args = []string{
 			fmt.Sprintf("--grpc-port=%v", grpcPort),
 			fmt.Sprintf("--http-port=%v", httpPort),
-			"--v=5",
+			fmt.Sprintf("--v=%v", logLevel),
 		}
+		container.Args = append(container.Args, extraArgs...)
 	}
 }
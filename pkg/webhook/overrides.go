@@ -0,0 +1,284 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	imageKey    = "carrier.ocgi.dev/sdkserver-image"
+	logLevelKey = "carrier.ocgi.dev/sdkserver-log-level"
+	// SideCarConfigSourceKey is the pod annotation recording the layer each side car value is resolved from
+	SideCarConfigSourceKey = "carrier.ocgi.dev/sidecar-config-source"
+)
+
+// Layers of the side car config, a value of a later layer overrides the earlier ones
+const (
+	SourceGlobal    = "global"
+	SourceNamespace = "namespace"
+	SourcePod       = "pod"
+)
+
+// Levels a side car override may be set at
+const (
+	levelNamespace = 1 << iota
+	levelPod
+	levelAll = levelNamespace | levelPod
+)
+
+// sideCarAnnotationPrefix is the prefix of side car annotations, the unknown keys of it are rejected
+const sideCarAnnotationPrefix = "carrier.ocgi.dev/sdkserver-"
+
+// sideCarOverride is a side car value set by the labels or annotations of namespaces and pods
+type sideCarOverride struct {
+	name string
+	key  string
+	// levels the override may be set at
+	levels int
+	// format describes the type and range of value
+	format string
+	// apply sets value into config, the error describes why value is invalid
+	apply func(config *SideCarConfig, value string) error
+	// fallback describes the value used if the override is invalid
+	fallback string
+}
+
+// sideCarOverrides is the registry of side car labels and annotations, in the order they are applied.
+// The image may only be set by namespaces, which are managed by cluster admins, so a pod can not run
+// an arbitrary side car with the permissions of the side car.
+var sideCarOverrides = []sideCarOverride{
+	{"image", imageKey, levelNamespace, "a non-empty image without whitespace",
+		func(c *SideCarConfig, value string) error {
+			if value == "" || strings.ContainsAny(value, " \t\n") {
+				return fmt.Errorf("is not a valid image")
+			}
+			c.Image = value
+			return nil
+		}, "the default image is used"},
+	{"logLevel", logLevelKey, levelAll, "a non-negative integer", func(c *SideCarConfig, value string) error {
+		level, err := strconv.Atoi(value)
+		if err != nil || level < 0 {
+			return fmt.Errorf("is not a non-negative integer")
+		}
+		c.LogLevel = level
+		return nil
+	}, "the default log level is used"},
+	{"grpcPort", grpcPortKey, levelAll, "an integer between 1 and 65535", func(c *SideCarConfig, value string) error {
+		return parsePort(value, &c.GrpcPort)
+	}, "the default port is used"},
+	{"httpPort", httpPortKey, levelAll, "an integer between 1 and 65535", func(c *SideCarConfig, value string) error {
+		return parsePort(value, &c.HttpPort)
+	}, "the default port is used"},
+	{"livenessProbe", livenessProbeKey, levelAll, `"false" or a JSON object of probe fields`,
+		func(c *SideCarConfig, value string) error {
+			return parseProbe(LivenessProbe, value, &c.Probes.Liveness)
+		}, "the default probe is used"},
+	{"readinessProbe", readinessProbeKey, levelAll, `"false" or a JSON object of probe fields`,
+		func(c *SideCarConfig, value string) error {
+			return parseProbe(ReadinessProbe, value, &c.Probes.Readiness)
+		}, "the default probe is used"},
+	{"startupProbe", startupProbeKey, levelAll, `"false" or a JSON object of probe fields`,
+		func(c *SideCarConfig, value string) error {
+			return parseProbe(StartupProbe, value, &c.Probes.Startup)
+		}, "the default probe is used"},
+	{"cpu", cpuKey, levelAll, "a non-negative quantity", func(c *SideCarConfig, value string) error {
+		return parseQuantity(value, &c.CPU)
+	}, "the default resource is used"},
+	{"memory", memoryKey, levelAll, "a non-negative quantity", func(c *SideCarConfig, value string) error {
+		return parseQuantity(value, &c.Memory)
+	}, "the default resource is used"},
+	{"ephemeralStorage", ephemeralStorageKey, levelAll, "a non-negative quantity",
+		func(c *SideCarConfig, value string) error {
+			return parseQuantity(value, &c.EphemeralStorage)
+		}, "the default resource is used"},
+	{"cpuLimit", cpuLimitKey, levelAll, "a non-negative quantity", func(c *SideCarConfig, value string) error {
+		return parseQuantity(value, &c.CPULimit)
+	}, "the default resource is used"},
+	{"memoryLimit", memoryLimitKey, levelAll, "a non-negative quantity", func(c *SideCarConfig, value string) error {
+		return parseQuantity(value, &c.MemoryLimit)
+	}, "the default resource is used"},
+	{"ephemeralStorageLimit", ephemeralStorageLimitKey, levelAll, "a non-negative quantity",
+		func(c *SideCarConfig, value string) error {
+			return parseQuantity(value, &c.EphemeralStorageLimit)
+		}, "the default resource is used"},
+	{"args", SideCarArgsKey, levelAll, "a JSON array of allowed flags like --name=value",
+		func(c *SideCarConfig, value string) error {
+			return parseArgs(value, c.AllowedArgs, &c.ExtraArgs)
+		}, "the args are not added"},
+	{"env", envKey, levelAll, "a JSON array of env with value, configMapKeyRef or secretKeyRef",
+		func(c *SideCarConfig, value string) error {
+			return parseEnv(value, &c.ExtraEnv)
+		}, "the env is not added"},
+	{"inject", InjectKey, levelAll, `"true" or "false"`, func(c *SideCarConfig, value string) error {
+		return parseInject(value, &c.InjectionDisabled)
+	}, "the injection policy is used"},
+}
+
+func parseQuantity(value string, q *resource.Quantity) error {
+	parsed, err := resource.ParseQuantity(value)
+	if err != nil {
+		return fmt.Errorf("is not a valid quantity")
+	}
+	if parsed.Sign() < 0 {
+		return fmt.Errorf("is negative")
+	}
+	*q = parsed
+	return nil
+}
+
+func parsePort(value string, port *int) error {
+	p, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("is not an integer")
+	}
+	if p < 1 || p > 65535 {
+		return fmt.Errorf("is not between 1 and 65535")
+	}
+	*port = p
+	return nil
+}
+
+// SideCarSources maps the side car values to the layers they are resolved from
+type SideCarSources map[string]string
+
+// String returns the sources like cpu=namespace,grpcPort=global in a stable order
+func (s SideCarSources) String() string {
+	values := make([]string, 0, len(sideCarOverrides))
+	for _, o := range sideCarOverrides {
+		if source, ok := s[o.name]; ok {
+			values = append(values, o.name+"="+source)
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+// ResolveSideCarConfig returns the side car config of pod. The global values are overridden by the
+// labels and then the annotations of namespace, which may be nil, and then the annotations of pod.
+// Invalid values are ignored and returned as warnings.
+func ResolveSideCarConfig(global *SideCarConfig, namespace *corev1.Namespace,
+	pod *corev1.Pod) (*SideCarConfig, SideCarSources, []string) {
+	config := *global
+	sources := SideCarSources{}
+	for _, o := range sideCarOverrides {
+		sources[o.name] = SourceGlobal
+	}
+	var warnings []string
+	if namespace != nil {
+		prefix := fmt.Sprintf("namespace %v: ", namespace.Name)
+		for _, w := range applyOverrides(&config, sources, SourceNamespace, namespace.Labels,
+			field.NewPath("metadata", "labels")) {
+			warnings = append(warnings, prefix+w)
+		}
+		for _, w := range applyOverrides(&config, sources, SourceNamespace, namespace.Annotations,
+			field.NewPath("metadata", "annotations")) {
+			warnings = append(warnings, prefix+w)
+		}
+	}
+	warnings = append(warnings, applyOverrides(&config, sources, SourcePod, pod.Annotations,
+		field.NewPath("metadata", "annotations"))...)
+	return &config, sources, warnings
+}
+
+// levelNames are the names of levels in messages
+var levelNames = map[int]string{levelNamespace: "namespaces", levelPod: "pods"}
+
+// applyOverrides sets the valid overrides of values into config and records source of them,
+// the invalid ones are returned as warnings.
+func applyOverrides(config *SideCarConfig, sources SideCarSources, source string, values map[string]string,
+	fldPath *field.Path) []string {
+	level := levelPod
+	if source == SourceNamespace {
+		level = levelNamespace
+	}
+	var warnings []string
+	for _, o := range sideCarOverrides {
+		value, ok := values[o.key]
+		if !ok {
+			continue
+		}
+		if o.levels&level == 0 {
+			warnings = append(warnings, warning(fldPath.Key(o.key),
+				fmt.Sprintf("is not allowed on %v, %v", levelNames[level], o.fallback)))
+			continue
+		}
+		if err := o.apply(config, value); err != nil {
+			warnings = append(warnings, warning(fldPath.Key(o.key), fmt.Sprintf("%q %v, %v", value, err, o.fallback)))
+			continue
+		}
+		sources[o.name] = source
+	}
+	return warnings
+}
+
+// validateSideCarAnnotations validates the side car annotations of a pod template against sideCarOverrides,
+// config decides the values allowed, e.g. the allowed args. The values not changed from old are skipped,
+// so objects created before the validation can still be updated.
+func validateSideCarAnnotations(annotations, old map[string]string, config *SideCarConfig,
+	fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for _, o := range sideCarOverrides {
+		value, ok := annotations[o.key]
+		if !ok {
+			continue
+		}
+		if oldValue, ok := old[o.key]; ok && oldValue == value {
+			continue
+		}
+		if o.levels&levelPod == 0 {
+			errs = append(errs, field.Forbidden(fldPath.Key(o.key), "is not allowed on pods"))
+			continue
+		}
+		scratch := *config
+		if err := o.apply(&scratch, value); err != nil {
+			errs = append(errs, field.Invalid(fldPath.Key(o.key), value, fmt.Sprintf("%v, must be %v", err, o.format)))
+		}
+	}
+	errs = append(errs, validateSideCarResourceAnnotations(annotations, old, fldPath)...)
+	var keys, supported []string
+	for key := range annotations {
+		if _, ok := old[key]; strings.HasPrefix(key, sideCarAnnotationPrefix) && !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, o := range sideCarOverrides {
+		if strings.HasPrefix(o.key, sideCarAnnotationPrefix) && o.levels&levelPod != 0 {
+			supported = append(supported, o.key)
+		}
+	}
+	for _, key := range keys {
+		if !isSideCarOverride(key) {
+			errs = append(errs, field.NotSupported(fldPath.Key(key), key, supported))
+		}
+	}
+	return errs
+}
+
+func isSideCarOverride(key string) bool {
+	for _, o := range sideCarOverrides {
+		if o.key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func testGlobalConfig() *SideCarConfig {
+	return &SideCarConfig{
+		Image:    "sdk:v1",
+		LogLevel: 5,
+		CPU:      resource.MustParse("100m"),
+		Memory:   resource.MustParse("100Mi"),
+		HttpPort: 9021,
+		GrpcPort: 9020,
+	}
+}
+
+func TestResolveSideCarConfig(t *testing.T) {
+	staging := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "staging",
+			Labels:      map[string]string{logLevelKey: "8", cpuKey: "200m"},
+			Annotations: map[string]string{imageKey: "sdk:v2-rc", cpuKey: "300m", memoryKey: "1Gi-"},
+		},
+	}
+	cases := []struct {
+		name        string
+		namespace   *corev1.Namespace
+		annotations map[string]string
+		image       string
+		logLevel    int
+		cpu         string
+		memory      string
+		grpcPort    int
+		overridden  SideCarSources
+		warnings    []string
+	}{
+		{
+			name:     "global",
+			image:    "sdk:v1",
+			logLevel: 5,
+			cpu:      "100m",
+			memory:   "100Mi",
+			grpcPort: 9020,
+		},
+		{
+			name:       "namespace annotations override labels",
+			namespace:  staging,
+			image:      "sdk:v2-rc",
+			logLevel:   8,
+			cpu:        "300m",
+			memory:     "100Mi",
+			grpcPort:   9020,
+			overridden: SideCarSources{"image": SourceNamespace, "logLevel": SourceNamespace, "cpu": SourceNamespace},
+			warnings: []string{
+				`namespace staging: metadata.annotations[carrier.ocgi.dev/sdkserver-memory]: "1Gi-" is not a valid quantity, the default resource is used`,
+			},
+		},
+		{
+			name:      "pod overrides namespace",
+			namespace: staging,
+			annotations: map[string]string{logLevelKey: "2", grpcPortKey: "7000", httpPortKey: "70000", cpuKey: "-1",
+				imageKey: "sdk:dev"},
+			image:    "sdk:v2-rc",
+			logLevel: 2,
+			cpu:      "300m",
+			memory:   "100Mi",
+			grpcPort: 7000,
+			overridden: SideCarSources{"image": SourceNamespace, "logLevel": SourcePod, "grpcPort": SourcePod,
+				"cpu": SourceNamespace},
+			warnings: []string{
+				`namespace staging: metadata.annotations[carrier.ocgi.dev/sdkserver-memory]: "1Gi-" is not a valid quantity, the default resource is used`,
+				`metadata.annotations[carrier.ocgi.dev/sdkserver-image]: is not allowed on pods, the default image is used`,
+				`metadata.annotations[carrier.ocgi.dev/http-port]: "70000" is not between 1 and 65535, the default port is used`,
+				`metadata.annotations[carrier.ocgi.dev/sdkserver-cpu]: "-1" is negative, the default resource is used`,
+			},
+		},
+	}
+	for _, c := range cases {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: c.annotations}}
+		config, sources, warnings := ResolveSideCarConfig(testGlobalConfig(), c.namespace, pod)
+		if config.Image != c.image || config.LogLevel != c.logLevel || config.GrpcPort != c.grpcPort ||
+			config.HttpPort != 9021 || config.CPU.String() != c.cpu || config.Memory.String() != c.memory {
+			t.Errorf("%v: unexpected config %+v", c.name, config)
+		}
+		for _, o := range sideCarOverrides {
+			desired, ok := c.overridden[o.name]
+			if !ok {
+				desired = SourceGlobal
+			}
+			if sources[o.name] != desired {
+				t.Errorf("%v: desired source of %v %v, got %v", c.name, o.name, desired, sources[o.name])
+			}
+		}
+		if !reflect.DeepEqual(warnings, c.warnings) {
+			t.Errorf("%v: desired warnings %v, got %v", c.name, c.warnings, warnings)
+		}
+	}
+}
+
+func TestMutatePodRecordsSources(t *testing.T) {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "staging", Annotations: map[string]string{imageKey: "sdk:v2-rc"}},
+	}
+	pod := defaultTestPod().Obj()
+	pod.Annotations = map[string]string{logLevelKey: "3"}
+	mutated, changes, _ := MutatePod(pod, namespace, nil, testGlobalConfig())
+	sideCar := mutated.Spec.Containers[len(mutated.Spec.Containers)-1]
+	if sideCar.Image != "sdk:v2-rc" || changes.SideCarImage != "sdk:v2-rc" {
+		t.Errorf("image of namespace should be used, got %v", sideCar.Image)
+	}
+	if args := strings.Join(sideCar.Args, " "); args != "--grpc-port=9020 --http-port=9021 --v=3" {
+		t.Errorf("unexpected args %v", args)
+	}
+	got := mutated.Annotations[SideCarConfigSourceKey]
+	if !strings.HasPrefix(got, "image=namespace,logLevel=pod,grpcPort=global,") {
+		t.Errorf("unexpected sources %v", got)
+	}
+	if _, ok := pod.Annotations[SideCarConfigSourceKey]; ok {
+		t.Errorf("original pod should not be modified")
+	}
+}
+
+func TestValidateSideCarAnnotations(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		old         map[string]string
+		desired     []string
+	}{
+		{
+			name: "valid",
+			annotations: map[string]string{grpcPortKey: "7000", cpuKey: "200m", readinessProbeKey: `{"path": "/ready"}`,
+				"carrier.ocgi.dev/gs-deletion-cost": "10"},
+		},
+		{
+			name: "invalid values",
+			annotations: map[string]string{grpcPortKey: "70000", logLevelKey: "-1", memoryKey: "1Gi-",
+				livenessProbeKey: `{"successThreshold": 2}`},
+			desired: []string{
+				`spec.template.metadata.annotations[carrier.ocgi.dev/sdkserver-log-level]: Invalid value: "-1": ` +
+					`is not a non-negative integer, must be a non-negative integer`,
+				`spec.template.metadata.annotations[carrier.ocgi.dev/grpc-port]: Invalid value: "70000": ` +
+					`is not between 1 and 65535, must be an integer between 1 and 65535`,
+				`spec.template.metadata.annotations[carrier.ocgi.dev/sdkserver-liveness-probe]: Invalid value: ` +
+					`"{\"successThreshold\": 2}": is not a valid probe: liveness.successThreshold: Invalid value: 2: ` +
+					`must be 1 for liveness probe, ` +
+					`must be "false" or a JSON object of probe fields`,
+				`spec.template.metadata.annotations[carrier.ocgi.dev/sdkserver-memory]: Invalid value: "1Gi-": ` +
+					`is not a valid quantity, must be a non-negative quantity`,
+			},
+		},
+		{
+			name:        "unknown key",
+			annotations: map[string]string{"carrier.ocgi.dev/sdkserver-memroy": "1Gi"},
+			desired: []string{
+				`spec.template.metadata.annotations[carrier.ocgi.dev/sdkserver-memroy]: Unsupported value: ` +
+					`"carrier.ocgi.dev/sdkserver-memroy": supported values: "carrier.ocgi.dev/sdkserver-log-level", ` +
+					`"carrier.ocgi.dev/sdkserver-liveness-probe", "carrier.ocgi.dev/sdkserver-readiness-probe", ` +
+					`"carrier.ocgi.dev/sdkserver-startup-probe", "carrier.ocgi.dev/sdkserver-cpu", ` +
+					`"carrier.ocgi.dev/sdkserver-memory", ` +
+					`"carrier.ocgi.dev/sdkserver-ephemeral-storage", "carrier.ocgi.dev/sdkserver-cpu-limit", ` +
+					`"carrier.ocgi.dev/sdkserver-memory-limit", "carrier.ocgi.dev/sdkserver-ephemeral-storage-limit", ` +
+					`"carrier.ocgi.dev/sdkserver-args", "carrier.ocgi.dev/sdkserver-env"`,
+			},
+		},
+		{
+			name:        "image not allowed on pods",
+			annotations: map[string]string{imageKey: "sdk:dev"},
+			desired: []string{
+				`spec.template.metadata.annotations[carrier.ocgi.dev/sdkserver-image]: Forbidden: is not allowed on pods`,
+			},
+		},
+		{
+			name:        "limit lower than request",
+			annotations: map[string]string{cpuKey: "500m", cpuLimitKey: "200m", memoryLimitKey: "1Gi"},
+			desired: []string{
+				`spec.template.metadata.annotations[carrier.ocgi.dev/sdkserver-cpu-limit]: Invalid value: "200m": ` +
+					`must be greater than or equal to carrier.ocgi.dev/sdkserver-cpu 500m`,
+			},
+		},
+		{
+			name:        "unchanged on update",
+			annotations: map[string]string{cpuKey: "1core", "carrier.ocgi.dev/sdkserver-memroy": "1Gi"},
+			old:         map[string]string{cpuKey: "1core", "carrier.ocgi.dev/sdkserver-memroy": "1Gi"},
+		},
+		{
+			name:        "changed on update",
+			annotations: map[string]string{cpuKey: "2core"},
+			old:         map[string]string{cpuKey: "1core"},
+			desired: []string{
+				`spec.template.metadata.annotations[carrier.ocgi.dev/sdkserver-cpu]: Invalid value: "2core": ` +
+					`is not a valid quantity, must be a non-negative quantity`,
+			},
+		},
+		{
+			name:        "allowed args",
+			annotations: map[string]string{SideCarArgsKey: `["--feature-gates=A=true"]`},
+		},
+		{
+			name:        "args not allowed",
+			annotations: map[string]string{SideCarArgsKey: `["--kubeconfig=/tmp/config"]`},
+			desired: []string{
+				`spec.template.metadata.annotations[carrier.ocgi.dev/sdkserver-args]: Invalid value: ` +
+					`"[\"--kubeconfig=/tmp/config\"]": has "--kubeconfig=/tmp/config" which is not allowed, ` +
+					`the allowed flags are [feature-gates], must be a JSON array of allowed flags like --name=value`,
+			},
+		},
+	}
+	config := testGlobalConfig()
+	config.AllowedArgs = []string{"feature-gates"}
+	for _, c := range cases {
+		var got []string
+		for _, err := range validateSideCarAnnotations(c.annotations, c.old, config,
+			field.NewPath("spec", "template", "metadata", "annotations")) {
+			got = append(got, err.Error())
+		}
+		if !reflect.DeepEqual(got, c.desired) {
+			t.Errorf("%v: desired %v, got %v", c.name, c.desired, got)
+		}
+	}
+}
+
+func TestValidateSquadSideCarAnnotations(t *testing.T) {
+	squad := defaultSquad()
+	squad.Spec.Template.Spec.Template.Annotations = map[string]string{httpPortKey: "http"}
+	desired := "spec.template.spec.template.metadata.annotations[carrier.ocgi.dev/http-port]"
+	for _, err := range ValidateSquad(squad, testGlobalConfig()).Errors {
+		if err.Field == desired {
+			return
+		}
+	}
+	t.Errorf("expected error of %v", desired)
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	carrierv1alpha1 "github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
+)
+
+// sideCarPort is a port the side car listens on
+type sideCarPort struct {
+	name string
+	port int
+	// key is the annotation overriding the port
+	key string
+}
+
+// ValidateSideCarPorts validates that the side car ports resolved for the pods of spec do not conflict
+// with the container ports and port ranges of spec, or with each other. On update old is the spec of the
+// old object, and the ports are validated only if the ports, containers or side car annotations changed,
+// so that objects created before a change of the side car ports can still be updated.
+func ValidateSideCarPorts(spec, old *carrierv1alpha1.GameServerSpec, config *SideCarConfig,
+	namespace *corev1.Namespace, fldPath *field.Path) field.ErrorList {
+	if old != nil && !sideCarPortsChanged(old, spec) {
+		return nil
+	}
+	pod := &corev1.Pod{ObjectMeta: spec.Template.ObjectMeta}
+	resolved, _, _ := ResolveSideCarConfig(config, namespace, pod)
+	if resolved.InjectionDisabled {
+		return nil
+	}
+	ports := sideCarPorts(resolved)
+	errs := validateSideCarPortsDistinct(ports, fldPath.Child("template", "metadata", "annotations"))
+	errs = append(errs, validateContainerPorts(ports, spec.Template.Spec.Containers,
+		fldPath.Child("template", "spec", "containers"))...)
+	for i, p := range spec.Ports {
+		portPath := fldPath.Child("ports").Index(i)
+		for _, sp := range ports {
+			if p.ContainerPort != nil && int(*p.ContainerPort) == sp.port {
+				errs = append(errs, portConflict(portPath.Child("containerPort"), *p.ContainerPort, sp))
+			}
+			if r := p.ContainerPortRange; r != nil && int(r.MinPort) <= sp.port && sp.port <= int(r.MaxPort) {
+				errs = append(errs, portConflict(portPath.Child("containerPortRange"),
+					fmt.Sprintf("%v-%v", r.MinPort, r.MaxPort), sp))
+			}
+		}
+	}
+	return errs
+}
+
+// ValidatePodSideCarPorts validates that the side car ports resolved for pod do not conflict with
+// the container ports of pod, or with each other.
+func ValidatePodSideCarPorts(pod *corev1.Pod, config *SideCarConfig, namespace *corev1.Namespace) field.ErrorList {
+	resolved, _, _ := ResolveSideCarConfig(config, namespace, pod)
+	ports := sideCarPorts(resolved)
+	errs := validateSideCarPortsDistinct(ports, field.NewPath("metadata", "annotations"))
+	return append(errs, validateContainerPorts(ports, pod.Spec.Containers, field.NewPath("spec", "containers"))...)
+}
+
+// sideCarPortsChanged returns true if the fields deciding the port conflicts of spec changed from old
+func sideCarPortsChanged(old, spec *carrierv1alpha1.GameServerSpec) bool {
+	return !apiequality.Semantic.DeepEqual(old.Ports, spec.Ports) ||
+		!apiequality.Semantic.DeepEqual(old.Template.Spec.Containers, spec.Template.Spec.Containers) ||
+		!reflect.DeepEqual(sideCarAnnotations(old.Template.Annotations), sideCarAnnotations(spec.Template.Annotations))
+}
+
+// sideCarAnnotations returns the side car overrides in annotations
+func sideCarAnnotations(annotations map[string]string) map[string]string {
+	overrides := map[string]string{}
+	for _, o := range sideCarOverrides {
+		if value, ok := annotations[o.key]; ok {
+			overrides[o.key] = value
+		}
+	}
+	return overrides
+}
+
+func sideCarPorts(config *SideCarConfig) []sideCarPort {
+	return []sideCarPort{
+		{name: "grpc", port: config.GrpcPort, key: grpcPortKey},
+		{name: "http", port: config.HttpPort, key: httpPortKey},
+	}
+}
+
+// validateSideCarPortsDistinct checks the grpc port against the http port, which is also the port of probes
+func validateSideCarPortsDistinct(ports []sideCarPort, annotationsPath *field.Path) field.ErrorList {
+	grpc, http := ports[0], ports[1]
+	if grpc.port != http.port {
+		return nil
+	}
+	return field.ErrorList{field.Invalid(annotationsPath.Key(grpc.key), grpc.port,
+		fmt.Sprintf("side car grpc port %v conflicts with its http and probe port, set %v or %v to another port",
+			grpc.port, grpc.key, http.key))}
+}
+
+func validateContainerPorts(ports []sideCarPort, containers []corev1.Container,
+	containersPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, c := range containers {
+		if c.Name == sdkServerSidecarName {
+			continue
+		}
+		for j, p := range c.Ports {
+			portPath := containersPath.Index(i).Child("ports").Index(j).Child("containerPort")
+			for _, sp := range ports {
+				if int(p.ContainerPort) == sp.port {
+					errs = append(errs, portConflict(portPath, p.ContainerPort, sp))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func portConflict(fldPath *field.Path, value interface{}, sp sideCarPort) *field.Error {
+	return field.Invalid(fldPath, value, fmt.Sprintf("conflicts with side car %v port %v, set %v to another port",
+		sp.name, sp.port, sp.key))
+}
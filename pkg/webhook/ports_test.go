@@ -0,0 +1,156 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	carrierv1alpha1 "github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
+)
+
+func TestValidateSideCarPorts(t *testing.T) {
+	containerPort := func(port int32) carrierv1alpha1.GameServerPort {
+		return carrierv1alpha1.GameServerPort{Name: "game", ContainerPort: &port}
+	}
+	portRange := carrierv1alpha1.GameServerPort{
+		Name:               "range",
+		ContainerPortRange: &carrierv1alpha1.PortRange{MinPort: 9000, MaxPort: 9020},
+	}
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		ports       []carrierv1alpha1.GameServerPort
+		container   []corev1.ContainerPort
+		namespace   *corev1.Namespace
+		desired     []string
+	}{
+		{
+			name:      "no conflict",
+			ports:     []carrierv1alpha1.GameServerPort{containerPort(7777)},
+			container: []corev1.ContainerPort{{ContainerPort: 7777}},
+		},
+		{
+			name:    "container port",
+			ports:   []carrierv1alpha1.GameServerPort{containerPort(9021)},
+			desired: []string{"spec.ports[0].containerPort"},
+		},
+		{
+			name:    "container port range",
+			ports:   []carrierv1alpha1.GameServerPort{containerPort(7777), portRange},
+			desired: []string{"spec.ports[1].containerPortRange"},
+		},
+		{
+			name:        "port overridden by pod",
+			annotations: map[string]string{httpPortKey: "7777"},
+			ports:       []carrierv1alpha1.GameServerPort{containerPort(7777)},
+			container:   []corev1.ContainerPort{{ContainerPort: 7777}},
+			desired: []string{
+				"spec.template.spec.containers[0].ports[0].containerPort",
+				"spec.ports[0].containerPort",
+			},
+		},
+		{
+			name: "grpc port overridden by namespace is http port",
+			namespace: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "game", Annotations: map[string]string{grpcPortKey: "9021"}},
+			},
+			desired: []string{"spec.template.metadata.annotations[carrier.ocgi.dev/grpc-port]"},
+		},
+	}
+	for _, c := range cases {
+		spec := &carrierv1alpha1.GameServerSpec{
+			Ports: c.ports,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Annotations: c.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "server", Ports: c.container}}},
+			},
+		}
+		var fields []string
+		for _, err := range ValidateSideCarPorts(spec, nil, testGlobalConfig(), c.namespace, field.NewPath("spec")) {
+			fields = append(fields, err.Field)
+		}
+		if !reflect.DeepEqual(fields, c.desired) {
+			t.Errorf("%v: desired errors of %v, got %v", c.name, c.desired, fields)
+		}
+	}
+}
+
+func TestValidatePodSideCarPorts(t *testing.T) {
+	pod := defaultTestPod().Obj()
+	pod.Annotations = map[string]string{grpcPortKey: "7777"}
+	pod.Spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: 7777}}
+	errs := ValidatePodSideCarPorts(pod, testGlobalConfig(), nil)
+	if len(errs) != 1 || errs[0].Field != "spec.containers[0].ports[0].containerPort" {
+		t.Fatalf("expected conflict of container port, got %v", errs)
+	}
+	desired := "conflicts with side car grpc port 7777, set carrier.ocgi.dev/grpc-port to another port"
+	if errs[0].Detail != desired {
+		t.Errorf("desired %q, got %q", desired, errs[0].Detail)
+	}
+}
+
+func TestValidateSideCarPortsUpdate(t *testing.T) {
+	port := int32(9021)
+	old := &carrierv1alpha1.GameServerSpec{
+		Ports: []carrierv1alpha1.GameServerPort{{Name: "game", ContainerPort: &port}},
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"team": "a"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "server"}}},
+		},
+	}
+	cases := []struct {
+		name   string
+		update func(spec *carrierv1alpha1.GameServerSpec)
+		errs   int
+	}{
+		{
+			name:   "unchanged",
+			update: func(spec *carrierv1alpha1.GameServerSpec) {},
+		},
+		{
+			name: "other annotation changed",
+			update: func(spec *carrierv1alpha1.GameServerSpec) {
+				spec.Template.Annotations = map[string]string{"team": "b"}
+			},
+		},
+		{
+			name: "side car annotation changed",
+			update: func(spec *carrierv1alpha1.GameServerSpec) {
+				spec.Template.Annotations = map[string]string{logLevelKey: "3"}
+			},
+			errs: 1,
+		},
+		{
+			name: "container changed",
+			update: func(spec *carrierv1alpha1.GameServerSpec) {
+				spec.Template.Spec.Containers[0].Image = "server:v2"
+			},
+			errs: 1,
+		},
+	}
+	for _, c := range cases {
+		spec := old.DeepCopy()
+		c.update(spec)
+		errs := ValidateSideCarPorts(spec, old, testGlobalConfig(), nil, field.NewPath("spec"))
+		if len(errs) != c.errs {
+			t.Errorf("%v: desired %v errors, got %v", c.name, c.errs, errs)
+		}
+	}
+}
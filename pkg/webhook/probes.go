@@ -0,0 +1,198 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	livenessProbeKey  = "carrier.ocgi.dev/sdkserver-liveness-probe"
+	readinessProbeKey = "carrier.ocgi.dev/sdkserver-readiness-probe"
+	startupProbeKey   = "carrier.ocgi.dev/sdkserver-startup-probe"
+	// probeDisabled is the override value removing a probe
+	probeDisabled = "false"
+)
+
+// Kinds of side car probes
+const (
+	LivenessProbe  = "liveness"
+	ReadinessProbe = "readiness"
+	StartupProbe   = "startup"
+)
+
+// ProbeConfig describes an HTTP probe of side car, the port is always the http port of side car
+type ProbeConfig struct {
+	Path                string `json:"path,omitempty"`
+	InitialDelaySeconds int32  `json:"initialDelaySeconds,omitempty"`
+	TimeoutSeconds      int32  `json:"timeoutSeconds,omitempty"`
+	PeriodSeconds       int32  `json:"periodSeconds,omitempty"`
+	SuccessThreshold    int32  `json:"successThreshold,omitempty"`
+	FailureThreshold    int32  `json:"failureThreshold,omitempty"`
+}
+
+// SideCarProbes are the probes of side car, a nil probe is not set
+type SideCarProbes struct {
+	Liveness  *ProbeConfig
+	Readiness *ProbeConfig
+	Startup   *ProbeConfig
+}
+
+// DefaultProbe returns the probe of /healthz
+func DefaultProbe() ProbeConfig {
+	return ProbeConfig{
+		Path:                "/healthz",
+		InitialDelaySeconds: 3,
+		TimeoutSeconds:      1,
+		PeriodSeconds:       10,
+		SuccessThreshold:    1,
+		FailureThreshold:    3,
+	}
+}
+
+// DefaultSideCarProbes returns the default liveness probe, readiness and startup probes are not set
+func DefaultSideCarProbes() SideCarProbes {
+	liveness := DefaultProbe()
+	return SideCarProbes{Liveness: &liveness}
+}
+
+// ValidateProbe returns the errors of a probe of kind which would never succeed or be rejected by apiserver,
+// a nil probe is valid.
+func ValidateProbe(kind string, p *ProbeConfig, fldPath *field.Path) field.ErrorList {
+	if p == nil {
+		return nil
+	}
+	var errs field.ErrorList
+	if !strings.HasPrefix(p.Path, "/") {
+		errs = append(errs, field.Invalid(fldPath.Child("path"), p.Path, "must start with /"))
+	}
+	if p.InitialDelaySeconds < 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("initialDelaySeconds"), p.InitialDelaySeconds,
+			"must not be negative"))
+	}
+	for _, f := range []struct {
+		name  string
+		value int32
+	}{
+		{"timeoutSeconds", p.TimeoutSeconds},
+		{"periodSeconds", p.PeriodSeconds},
+		{"successThreshold", p.SuccessThreshold},
+		{"failureThreshold", p.FailureThreshold},
+	} {
+		if f.value < 1 {
+			errs = append(errs, field.Invalid(fldPath.Child(f.name), f.value, "must be at least 1"))
+		}
+	}
+	if kind != ReadinessProbe && p.SuccessThreshold > 1 {
+		errs = append(errs, field.Invalid(fldPath.Child("successThreshold"), p.SuccessThreshold,
+			fmt.Sprintf("must be 1 for %v probe", kind)))
+	}
+	if p.TimeoutSeconds > p.PeriodSeconds && p.PeriodSeconds >= 1 {
+		errs = append(errs, field.Invalid(fldPath.Child("timeoutSeconds"), p.TimeoutSeconds,
+			"must not be greater than periodSeconds"))
+	}
+	return errs
+}
+
+// parseProbe parses the override of a probe, "false" removes the probe, otherwise the JSON fields
+// are merged into probe, or the default probe if probe is nil.
+func parseProbe(kind, value string, probe **ProbeConfig) error {
+	if value == probeDisabled {
+		*probe = nil
+		return nil
+	}
+	p := DefaultProbe()
+	if *probe != nil {
+		p = **probe
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&p); err != nil {
+		return fmt.Errorf("is neither %v nor a JSON probe: %v", probeDisabled, err)
+	}
+	if errs := ValidateProbe(kind, &p, field.NewPath(kind)); len(errs) != 0 {
+		return fmt.Errorf("is not a valid probe: %v", errs.ToAggregate())
+	}
+	*probe = &p
+	return nil
+}
+
+// WithHealthCheck sets the probes of side car on its http port
+func WithHealthCheck(probes SideCarProbes, httpPort int) option {
+	return func(container *corev1.Container) {
+		container.LivenessProbe = toProbe(probes.Liveness, httpPort)
+		container.ReadinessProbe = toProbe(probes.Readiness, httpPort)
+		container.StartupProbe = toProbe(probes.Startup, httpPort)
+	}
+}
+
+func toProbe(p *ProbeConfig, httpPort int) *corev1.Probe {
+	if p == nil {
+		return nil
+	}
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: p.Path,
+				Port: intstr.FromInt(httpPort),
+			},
+		},
+		InitialDelaySeconds: p.InitialDelaySeconds,
+		TimeoutSeconds:      p.TimeoutSeconds,
+		PeriodSeconds:       p.PeriodSeconds,
+		SuccessThreshold:    p.SuccessThreshold,
+		FailureThreshold:    p.FailureThreshold,
+	}
+}
+
+// fromProbe returns the fields of a template probe checked by ValidateProbe, the unset ones are
+// defaulted like apiserver. The path of a probe other than HTTP is /, only HTTP paths are checked.
+func fromProbe(p *corev1.Probe) *ProbeConfig {
+	if p == nil {
+		return nil
+	}
+	c := &ProbeConfig{
+		Path:                "/",
+		InitialDelaySeconds: p.InitialDelaySeconds,
+		TimeoutSeconds:      p.TimeoutSeconds,
+		PeriodSeconds:       p.PeriodSeconds,
+		SuccessThreshold:    p.SuccessThreshold,
+		FailureThreshold:    p.FailureThreshold,
+	}
+	if p.HTTPGet != nil && p.HTTPGet.Path != "" {
+		c.Path = p.HTTPGet.Path
+	}
+	for _, f := range []struct {
+		field        *int32
+		defaultValue int32
+	}{
+		{&c.TimeoutSeconds, 1},
+		{&c.PeriodSeconds, 10},
+		{&c.SuccessThreshold, 1},
+		{&c.FailureThreshold, 3},
+	} {
+		if *f.field == 0 {
+			*f.field = f.defaultValue
+		}
+	}
+	return c
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestParseProbe(t *testing.T) {
+	liveness := DefaultProbe()
+	cases := []struct {
+		name    string
+		kind    string
+		probe   *ProbeConfig
+		value   string
+		desired *ProbeConfig
+		invalid bool
+	}{
+		{
+			name:  "disable",
+			kind:  LivenessProbe,
+			probe: &liveness,
+			value: "false",
+		},
+		{
+			name:    "merge into configured probe",
+			kind:    LivenessProbe,
+			probe:   &ProbeConfig{Path: "/live", TimeoutSeconds: 2, PeriodSeconds: 5, SuccessThreshold: 1, FailureThreshold: 1},
+			value:   `{"failureThreshold": 6}`,
+			desired: &ProbeConfig{Path: "/live", TimeoutSeconds: 2, PeriodSeconds: 5, SuccessThreshold: 1, FailureThreshold: 6},
+		},
+		{
+			name:    "enable from default",
+			kind:    ReadinessProbe,
+			value:   `{"path": "/ready", "successThreshold": 2}`,
+			desired: &ProbeConfig{Path: "/ready", InitialDelaySeconds: 3, TimeoutSeconds: 1, PeriodSeconds: 10, SuccessThreshold: 2, FailureThreshold: 3},
+		},
+		{
+			name:    "success threshold of startup probe",
+			kind:    StartupProbe,
+			value:   `{"successThreshold": 2}`,
+			invalid: true,
+		},
+		{
+			name:    "timeout longer than period",
+			kind:    ReadinessProbe,
+			value:   `{"timeoutSeconds": 30}`,
+			invalid: true,
+		},
+		{
+			name:    "relative path",
+			kind:    ReadinessProbe,
+			value:   `{"path": "healthz"}`,
+			invalid: true,
+		},
+		{
+			name:    "unknown field",
+			kind:    ReadinessProbe,
+			value:   `{"port": 8080}`,
+			invalid: true,
+		},
+	}
+	for _, c := range cases {
+		probe := c.probe
+		err := parseProbe(c.kind, c.value, &probe)
+		if (err != nil) != c.invalid {
+			t.Errorf("%v: desired invalid %v, got %v", c.name, c.invalid, err)
+			continue
+		}
+		if c.invalid {
+			if probe != c.probe {
+				t.Errorf("%v: invalid override should keep the probe", c.name)
+			}
+			continue
+		}
+		if (probe == nil) != (c.desired == nil) || (probe != nil && *probe != *c.desired) {
+			t.Errorf("%v: desired %+v, got %+v", c.name, c.desired, probe)
+		}
+	}
+	if liveness != DefaultProbe() {
+		t.Errorf("configured probe should not be modified")
+	}
+}
+
+func TestMutatePodProbes(t *testing.T) {
+	config := testGlobalConfig()
+	config.Probes = DefaultSideCarProbes()
+	pod := defaultTestPod().Obj()
+	pod.Annotations = map[string]string{
+		httpPortKey:       "7001",
+		readinessProbeKey: `{"path": "/ready"}`,
+	}
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "game", Annotations: map[string]string{livenessProbeKey: "false"}},
+	}
+	mutated, _, _ := MutatePod(pod, namespace, nil, config)
+	sideCar := mutated.Spec.Containers[len(mutated.Spec.Containers)-1]
+	if sideCar.LivenessProbe != nil || sideCar.StartupProbe != nil {
+		t.Errorf("liveness probe should be disabled by namespace and startup probe is not set, got %+v", sideCar)
+	}
+	if sideCar.ReadinessProbe == nil || sideCar.ReadinessProbe.HTTPGet.Path != "/ready" ||
+		sideCar.ReadinessProbe.HTTPGet.Port != intstr.FromInt(7001) {
+		t.Errorf("readiness probe should use /ready on the http port of pod, got %+v", sideCar.ReadinessProbe)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/kubernetes/pkg/apis/core/v1/helper/qos"
+)
+
+const (
+	ephemeralStorageKey      = "carrier.ocgi.dev/sdkserver-ephemeral-storage"
+	cpuLimitKey              = "carrier.ocgi.dev/sdkserver-cpu-limit"
+	memoryLimitKey           = "carrier.ocgi.dev/sdkserver-memory-limit"
+	ephemeralStorageLimitKey = "carrier.ocgi.dev/sdkserver-ephemeral-storage-limit"
+)
+
+// guaranteedDefaults are the cpu and memory of side car in a Guaranteed pod if not configured, a Guaranteed
+// pod requires both of them on every container
+var guaranteedDefaults = corev1.ResourceList{
+	corev1.ResourceCPU:    resource.MustParse("100m"),
+	corev1.ResourceMemory: resource.MustParse("100Mi"),
+}
+
+// sideCarResource is a resource of side car with its request and limit in SideCarConfig
+type sideCarResource struct {
+	name     corev1.ResourceName
+	request  func(c *SideCarConfig) resource.Quantity
+	limit    func(c *SideCarConfig) resource.Quantity
+	key      string
+	limitKey string
+}
+
+// resourceOverrides are the side car resources whose requests and limits are overridden separately
+var resourceOverrides = []sideCarResource{
+	{
+		name:     corev1.ResourceCPU,
+		request:  func(c *SideCarConfig) resource.Quantity { return c.CPU },
+		limit:    func(c *SideCarConfig) resource.Quantity { return c.CPULimit },
+		key:      cpuKey,
+		limitKey: cpuLimitKey,
+	},
+	{
+		name:     corev1.ResourceMemory,
+		request:  func(c *SideCarConfig) resource.Quantity { return c.Memory },
+		limit:    func(c *SideCarConfig) resource.Quantity { return c.MemoryLimit },
+		key:      memoryKey,
+		limitKey: memoryLimitKey,
+	},
+	{
+		name:     corev1.ResourceEphemeralStorage,
+		request:  func(c *SideCarConfig) resource.Quantity { return c.EphemeralStorage },
+		limit:    func(c *SideCarConfig) resource.Quantity { return c.EphemeralStorageLimit },
+		key:      ephemeralStorageKey,
+		limitKey: ephemeralStorageLimitKey,
+	},
+}
+
+// SideCarResources returns the resources of side car in pod. A limit not set is the request, and a limit
+// lower than the request is raised to it. If pod is Guaranteed, the requests are raised to the limits so
+// that the side car does not downgrade the pod to Burstable, and the cpu or memory not configured is set
+// to guaranteedDefaults. The warnings describe the adjustments.
+func SideCarResources(pod *corev1.Pod, config *SideCarConfig) (corev1.ResourceRequirements, []string) {
+	guaranteed := qos.GetPodQOS(pod) == corev1.PodQOSGuaranteed
+	resources := corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+	var warnings []string
+	for _, r := range resourceOverrides {
+		request, limit := r.request(config), r.limit(config)
+		if limit.IsZero() || limit.Cmp(request) < 0 {
+			if !limit.IsZero() {
+				warnings = append(warnings, fmt.Sprintf("side car %v limit %v is lower than the request %v, "+
+					"the request is used", r.name, limit.String(), request.String()))
+			}
+			limit = request
+		}
+		if guaranteed {
+			request = limit
+		}
+		if !request.IsZero() {
+			resources.Requests[r.name] = request
+		}
+		if !limit.IsZero() {
+			resources.Limits[r.name] = limit
+		}
+	}
+	if guaranteed {
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			if _, ok := resources.Limits[name]; ok {
+				continue
+			}
+			q := guaranteedDefaults[name]
+			resources.Requests[name], resources.Limits[name] = q, q
+			warnings = append(warnings, fmt.Sprintf("side car has no %v, %v is used so that the pod stays Guaranteed",
+				name, q.String()))
+		}
+	}
+	return resources, warnings
+}
+
+// validateSideCarResourceAnnotations validates that the limits are not lower than the requests in annotations,
+// the pairs not changed from old are skipped.
+func validateSideCarResourceAnnotations(annotations, old map[string]string, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for _, r := range resourceOverrides {
+		if old != nil && old[r.key] == annotations[r.key] && old[r.limitKey] == annotations[r.limitKey] {
+			continue
+		}
+		var request, limit resource.Quantity
+		if parseQuantity(annotations[r.key], &request) != nil || parseQuantity(annotations[r.limitKey], &limit) != nil {
+			continue
+		}
+		if limit.Cmp(request) < 0 {
+			errs = append(errs, field.Invalid(fldPath.Key(r.limitKey), annotations[r.limitKey],
+				fmt.Sprintf("must be greater than or equal to %v %v", r.key, request.String())))
+		}
+	}
+	return errs
+}
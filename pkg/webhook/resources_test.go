@@ -0,0 +1,128 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/kubernetes/pkg/apis/core/v1/helper/qos"
+)
+
+func TestSideCarResources(t *testing.T) {
+	guaranteed := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("2"),
+		corev1.ResourceMemory: resource.MustParse("4Gi"),
+	}
+	burstable := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}
+	cases := []struct {
+		name     string
+		game     corev1.ResourceRequirements
+		config   func(c *SideCarConfig)
+		requests string
+		limits   string
+		qos      corev1.PodQOSClass
+		warnings int
+	}{
+		{
+			name:     "limits default to requests",
+			requests: "cpu=100m,memory=100Mi",
+			limits:   "cpu=100m,memory=100Mi",
+			qos:      corev1.PodQOSBurstable,
+		},
+		{
+			name: "burstable cpu",
+			game: corev1.ResourceRequirements{Requests: burstable},
+			config: func(c *SideCarConfig) {
+				c.CPULimit = resource.MustParse("500m")
+				c.EphemeralStorage = resource.MustParse("1Gi")
+			},
+			requests: "cpu=100m,memory=100Mi,ephemeral-storage=1Gi",
+			limits:   "cpu=500m,memory=100Mi,ephemeral-storage=1Gi",
+			qos:      corev1.PodQOSBurstable,
+		},
+		{
+			name: "guaranteed pod",
+			game: corev1.ResourceRequirements{Requests: guaranteed, Limits: guaranteed},
+			config: func(c *SideCarConfig) {
+				c.CPULimit = resource.MustParse("500m")
+			},
+			requests: "cpu=500m,memory=100Mi",
+			limits:   "cpu=500m,memory=100Mi",
+			qos:      corev1.PodQOSGuaranteed,
+		},
+		{
+			name: "limit lower than request",
+			config: func(c *SideCarConfig) {
+				c.MemoryLimit = resource.MustParse("50Mi")
+			},
+			requests: "cpu=100m,memory=100Mi",
+			limits:   "cpu=100m,memory=100Mi",
+			qos:      corev1.PodQOSBurstable,
+			warnings: 1,
+		},
+		{
+			name: "guaranteed pod stays guaranteed without side car memory",
+			game: corev1.ResourceRequirements{Requests: guaranteed, Limits: guaranteed},
+			config: func(c *SideCarConfig) {
+				c.Memory = resource.Quantity{}
+			},
+			requests: "cpu=100m,memory=100Mi",
+			limits:   "cpu=100m,memory=100Mi",
+			qos:      corev1.PodQOSGuaranteed,
+			warnings: 1,
+		},
+	}
+	for _, c := range cases {
+		config := testGlobalConfig()
+		if c.config != nil {
+			c.config(config)
+		}
+		pod := defaultTestPod().Obj()
+		for i := range pod.Spec.Containers {
+			pod.Spec.Containers[i].Resources = c.game
+		}
+		mutated, _, warnings := MutatePod(pod, nil, nil, config)
+		sideCar := mutated.Spec.Containers[len(mutated.Spec.Containers)-1]
+		if got := resourceString(sideCar.Resources.Requests); got != c.requests {
+			t.Errorf("%v: desired requests %v, got %v", c.name, c.requests, got)
+		}
+		if got := resourceString(sideCar.Resources.Limits); got != c.limits {
+			t.Errorf("%v: desired limits %v, got %v", c.name, c.limits, got)
+		}
+		if got := qos.GetPodQOS(mutated); got != c.qos {
+			t.Errorf("%v: desired QoS %v, got %v", c.name, c.qos, got)
+		}
+		if len(warnings) != c.warnings {
+			t.Errorf("%v: desired %v warnings, got %v", c.name, c.warnings, warnings)
+		}
+	}
+}
+
+func resourceString(list corev1.ResourceList) string {
+	var s string
+	for _, name := range []corev1.ResourceName{
+		corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage,
+	} {
+		if q, ok := list[name]; ok {
+			if s != "" {
+				s += ","
+			}
+			s += string(name) + "=" + q.String()
+		}
+	}
+	return s
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// SupportedReviewVersions are the AdmissionReview versions the webhook handles, the preferred first
+var SupportedReviewVersions = []string{admissionv1.SchemeGroupVersion.Version, admissionv1beta1.SchemeGroupVersion.Version}
+
+// decodeReview decodes an AdmissionReview of any supported version, the request is converted to v1.
+// The returned version is used to encode the response.
+func decodeReview(body []byte) (*admissionv1.AdmissionReview, schema.GroupVersionKind, error) {
+	obj, gvk, err := deserializer.Decode(body, nil, nil)
+	if err != nil {
+		if runtime.IsNotRegisteredError(err) {
+			return nil, schema.GroupVersionKind{}, fmt.Errorf("unsupported AdmissionReview version, "+
+				"expect one of %v: %v", SupportedReviewVersions, err)
+		}
+		return nil, schema.GroupVersionKind{}, err
+	}
+	switch review := obj.(type) {
+	case *admissionv1.AdmissionReview:
+		return review, *gvk, nil
+	case *admissionv1beta1.AdmissionReview:
+		ar := &admissionv1.AdmissionReview{}
+		if review.Request != nil {
+			ar.Request = convertRequestFromV1beta1(review.Request)
+		}
+		return ar, *gvk, nil
+	}
+	return nil, schema.GroupVersionKind{}, fmt.Errorf("unsupported kind %v, expect AdmissionReview", gvk)
+}
+
+// encodeReview builds the AdmissionReview of the version gvk from ar.
+func encodeReview(ar *admissionv1.AdmissionReview, gvk schema.GroupVersionKind) runtime.Object {
+	if gvk.GroupVersion() == admissionv1beta1.SchemeGroupVersion {
+		review := &admissionv1beta1.AdmissionReview{}
+		review.SetGroupVersionKind(gvk)
+		if ar.Response != nil {
+			review.Response = convertResponseToV1beta1(ar.Response)
+		}
+		return review
+	}
+	review := &admissionv1.AdmissionReview{Response: ar.Response}
+	review.SetGroupVersionKind(admissionv1.SchemeGroupVersion.WithKind("AdmissionReview"))
+	return review
+}
+
+func convertRequestFromV1beta1(req *admissionv1beta1.AdmissionRequest) *admissionv1.AdmissionRequest {
+	return &admissionv1.AdmissionRequest{
+		UID:                req.UID,
+		Kind:               req.Kind,
+		Resource:           req.Resource,
+		SubResource:        req.SubResource,
+		RequestKind:        req.RequestKind,
+		RequestResource:    req.RequestResource,
+		RequestSubResource: req.RequestSubResource,
+		Name:               req.Name,
+		Namespace:          req.Namespace,
+		Operation:          admissionv1.Operation(req.Operation),
+		UserInfo:           req.UserInfo,
+		Object:             req.Object,
+		OldObject:          req.OldObject,
+		DryRun:             req.DryRun,
+		Options:            req.Options,
+	}
+}
+
+func convertResponseToV1beta1(resp *admissionv1.AdmissionResponse) *admissionv1beta1.AdmissionResponse {
+	ret := &admissionv1beta1.AdmissionResponse{
+		UID:              resp.UID,
+		Allowed:          resp.Allowed,
+		Result:           resp.Result,
+		Patch:            resp.Patch,
+		AuditAnnotations: resp.AuditAnnotations,
+		Warnings:         resp.Warnings,
+	}
+	if resp.PatchType != nil {
+		pType := admissionv1beta1.PatchType(*resp.PatchType)
+		ret.PatchType = &pType
+	}
+	return ret
+}
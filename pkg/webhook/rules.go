@@ -0,0 +1,55 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// MutatePath is the path of mutating webhook
+	MutatePath = "/mutate"
+	// ValidatePath is the path of validating webhook
+	ValidatePath = "/validate"
+)
+
+// kindRule describes a resource handled by the webhook
+type kindRule struct {
+	groupVersion schema.GroupVersion
+	resource     string
+	operations   []admissionregistrationv1.OperationType
+}
+
+var createAndUpdate = []admissionregistrationv1.OperationType{
+	admissionregistrationv1.Create, admissionregistrationv1.Update,
+}
+
+func toRules(kinds []kindRule) []admissionregistrationv1.RuleWithOperations {
+	scope := admissionregistrationv1.NamespacedScope
+	rules := make([]admissionregistrationv1.RuleWithOperations, 0, len(kinds))
+	for _, k := range kinds {
+		rules = append(rules, admissionregistrationv1.RuleWithOperations{
+			Operations: k.operations,
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{k.groupVersion.Group},
+				APIVersions: []string{k.groupVersion.Version},
+				Resources:   []string{k.resource},
+				Scope:       &scope,
+			},
+		})
+	}
+	return rules
+}
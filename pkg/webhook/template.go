@@ -0,0 +1,315 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+// SideCarTemplateKey is the key of the container template in the ConfigMap
+const SideCarTemplateKey = "sidecar.yaml"
+
+// Placeholders expanded in the command, args, working dir, env values and probes of the template
+const (
+	GameServerNamePlaceholder = "${GAMESERVER_NAME}"
+	NamespacePlaceholder      = "${POD_NAMESPACE}"
+	GRPCPortPlaceholder       = "${GRPC_PORT}"
+	HTTPPortPlaceholder       = "${HTTP_PORT}"
+)
+
+var (
+	placeholders = []string{GameServerNamePlaceholder, NamespacePlaceholder, GRPCPortPlaceholder,
+		HTTPPortPlaceholder}
+	placeholderPattern = regexp.MustCompile(`\$\{[^}]*\}`)
+)
+
+// ParseSideCarTemplate decodes a container template, unknown fields and placeholders are rejected.
+// The args are checked like the args annotation, only allowedArgs may be set.
+func ParseSideCarTemplate(data []byte, allowedArgs []string) (*corev1.Container, error) {
+	container := &corev1.Container{}
+	if err := yaml.UnmarshalStrict(data, container); err != nil {
+		return nil, fmt.Errorf("decode side car template failed: %v", err)
+	}
+	if container.Name != "" && container.Name != sdkServerSidecarName {
+		return nil, fmt.Errorf("name of side car template must be empty or %v, got %v",
+			sdkServerSidecarName, container.Name)
+	}
+	// the resources follow the QoS class of pod, see SideCarResources
+	if container.Resources.Limits != nil || container.Resources.Requests != nil {
+		return nil, fmt.Errorf("resources of side car template are not supported, " +
+			"set the requests and limits by the side car flags or annotations")
+	}
+	// the image is resolved with the overrides of namespaces and recorded in the audit annotations
+	if container.Image != "" {
+		return nil, fmt.Errorf("image of side car template is not supported, " +
+			"set the image by the side car flag or the namespace annotation")
+	}
+	var errs field.ErrorList
+	for _, p := range []struct {
+		kind  string
+		probe *corev1.Probe
+	}{
+		{LivenessProbe, container.LivenessProbe},
+		{ReadinessProbe, container.ReadinessProbe},
+		{StartupProbe, container.StartupProbe},
+	} {
+		errs = append(errs, ValidateProbe(p.kind, fromProbe(p.probe), field.NewPath(p.kind+"Probe"))...)
+	}
+	if len(errs) != 0 {
+		return nil, fmt.Errorf("invalid probes of side car template: %v", errs.ToAggregate())
+	}
+	if err := validateArgs(container.Args, allowedArgs); err != nil {
+		return nil, fmt.Errorf("args of side car template %v", err)
+	}
+	for _, p := range placeholderPattern.FindAllString(string(data), -1) {
+		if !contains(placeholders, p) {
+			return nil, fmt.Errorf("unknown placeholder %v in side car template, expect one of %v", p, placeholders)
+		}
+	}
+	// the placeholders left after expanding are in the fields expandTemplate does not expand
+	var pairs []string
+	for _, p := range placeholders {
+		pairs = append(pairs, p, "")
+	}
+	expanded, err := json.Marshal(expandTemplate(container, strings.NewReplacer(pairs...)))
+	if err != nil {
+		return nil, fmt.Errorf("encode side car template failed: %v", err)
+	}
+	if p := placeholderPattern.FindString(string(expanded)); p != "" {
+		return nil, fmt.Errorf("placeholder %v in side car template is only expanded in the command, args, "+
+			"working dir, env values and probes", p)
+	}
+	return container, nil
+}
+
+// WithTemplate merges the template into side car after the other options. Env and volume mounts
+// are added or replace the ones of the same name, args are appended, and the other fields set
+// in the template replace the built ones.
+func WithTemplate(template *corev1.Container, pod *corev1.Pod, httpPort, grpcPort int) option {
+	return func(container *corev1.Container) {
+		if template == nil {
+			return
+		}
+		t := expandTemplate(template, strings.NewReplacer(
+			GameServerNamePlaceholder, pod.Name,
+			NamespacePlaceholder, pod.Namespace,
+			GRPCPortPlaceholder, strconv.Itoa(grpcPort),
+			HTTPPortPlaceholder, strconv.Itoa(httpPort),
+		))
+		if t.ImagePullPolicy != "" {
+			container.ImagePullPolicy = t.ImagePullPolicy
+		}
+		if len(t.Command) != 0 {
+			container.Command = t.Command
+		}
+		if t.WorkingDir != "" {
+			container.WorkingDir = t.WorkingDir
+		}
+		if len(t.Ports) != 0 {
+			container.Ports = t.Ports
+		}
+		if t.LivenessProbe != nil {
+			container.LivenessProbe = t.LivenessProbe
+		}
+		if t.ReadinessProbe != nil {
+			container.ReadinessProbe = t.ReadinessProbe
+		}
+		if t.StartupProbe != nil {
+			container.StartupProbe = t.StartupProbe
+		}
+		if t.Lifecycle != nil {
+			container.Lifecycle = t.Lifecycle
+		}
+		if t.SecurityContext != nil {
+			container.SecurityContext = t.SecurityContext
+		}
+		container.Args = append(container.Args, t.Args...)
+		container.EnvFrom = append(container.EnvFrom, t.EnvFrom...)
+		for _, env := range t.Env {
+			container.Env = mergeEnv(container.Env, env)
+		}
+		for _, mount := range t.VolumeMounts {
+			container.VolumeMounts = mergeVolumeMount(container.VolumeMounts, mount)
+		}
+	}
+}
+
+// expandTemplate replaces the placeholders of a copy of template
+func expandTemplate(template *corev1.Container, r *strings.Replacer) *corev1.Container {
+	t := template.DeepCopy()
+	expand := func(values []string) {
+		for i := range values {
+			values[i] = r.Replace(values[i])
+		}
+	}
+	expand(t.Command)
+	expand(t.Args)
+	t.WorkingDir = r.Replace(t.WorkingDir)
+	for i := range t.Env {
+		t.Env[i].Value = r.Replace(t.Env[i].Value)
+	}
+	for _, probe := range []*corev1.Probe{t.LivenessProbe, t.ReadinessProbe, t.StartupProbe} {
+		if probe == nil {
+			continue
+		}
+		if probe.Exec != nil {
+			expand(probe.Exec.Command)
+		}
+		if probe.HTTPGet != nil {
+			probe.HTTPGet.Path = r.Replace(probe.HTTPGet.Path)
+			probe.HTTPGet.Port = expandPort(probe.HTTPGet.Port, r)
+		}
+		if probe.TCPSocket != nil {
+			probe.TCPSocket.Port = expandPort(probe.TCPSocket.Port, r)
+		}
+	}
+	return t
+}
+
+// expandPort replaces the placeholders of a port name, numbers are converted to int
+func expandPort(port intstr.IntOrString, r *strings.Replacer) intstr.IntOrString {
+	if port.Type != intstr.String {
+		return port
+	}
+	value := r.Replace(port.StrVal)
+	if n, err := strconv.Atoi(value); err == nil {
+		return intstr.FromInt(n)
+	}
+	return intstr.FromString(value)
+}
+
+func mergeEnv(envs []corev1.EnvVar, env corev1.EnvVar) []corev1.EnvVar {
+	for i := range envs {
+		if envs[i].Name == env.Name {
+			envs[i] = env
+			return envs
+		}
+	}
+	return append(envs, env)
+}
+
+func mergeVolumeMount(mounts []corev1.VolumeMount, mount corev1.VolumeMount) []corev1.VolumeMount {
+	for i := range mounts {
+		if mounts[i].Name == mount.Name {
+			mounts[i] = mount
+			return mounts
+		}
+	}
+	return append(mounts, mount)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// SideCarTemplateWatcher keeps the side car template of a ConfigMap up to date.
+// An invalid template is ignored and the last valid one is kept.
+type SideCarTemplateWatcher struct {
+	namespace, name string
+	allowedArgs     []string
+	informer        cache.SharedIndexInformer
+	mu              sync.RWMutex
+	template        *corev1.Container
+}
+
+// NewSideCarTemplateWatcher creates the watcher of ConfigMap namespace/name, it is run by factory.
+// allowedArgs are the flags the template args may set.
+func NewSideCarTemplateWatcher(client kubernetes.Interface, namespace, name string,
+	allowedArgs []string) (*SideCarTemplateWatcher, informers.SharedInformerFactory) {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	w := &SideCarTemplateWatcher{
+		namespace:   namespace,
+		name:        name,
+		allowedArgs: allowedArgs,
+		informer:    factory.Core().V1().ConfigMaps().Informer(),
+	}
+	w.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.update,
+		UpdateFunc: func(_, obj interface{}) { w.update(obj) },
+		DeleteFunc: func(obj interface{}) {
+			if key, _ := cache.DeletionHandlingMetaNamespaceKeyFunc(obj); key != namespace+"/"+name {
+				return
+			}
+			klog.Infof("ConfigMap %v/%v deleted, the built-in side car is used", namespace, name)
+			w.set(nil)
+		},
+	})
+	return w, factory
+}
+
+// Template returns the current template, nil if the ConfigMap does not exist
+func (w *SideCarTemplateWatcher) Template() *corev1.Container {
+	if w == nil {
+		return nil
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.template
+}
+
+// HasSynced returns true if the ConfigMap has been listed
+func (w *SideCarTemplateWatcher) HasSynced() bool {
+	return w == nil || w.informer.HasSynced()
+}
+
+func (w *SideCarTemplateWatcher) update(obj interface{}) {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok || cm.Name != w.name {
+		return
+	}
+	data, ok := cm.Data[SideCarTemplateKey]
+	if !ok {
+		klog.Errorf("ConfigMap %v/%v has no key %v, side car template is not changed", w.namespace, w.name,
+			SideCarTemplateKey)
+		return
+	}
+	template, err := ParseSideCarTemplate([]byte(data), w.allowedArgs)
+	if err != nil {
+		klog.Errorf("Invalid side car template of ConfigMap %v/%v, it is not changed: %v", w.namespace, w.name, err)
+		return
+	}
+	klog.Infof("Side car template updated from ConfigMap %v/%v", w.namespace, w.name)
+	w.set(template)
+}
+
+func (w *SideCarTemplateWatcher) set(template *corev1.Container) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.template = template
+}
@@ -0,0 +1,159 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testTemplate = `
+args: ["--gameserver=${GAMESERVER_NAME}"]
+env:
+- name: GAMESERVER_NAME
+  value: ${POD_NAMESPACE}/${GAMESERVER_NAME}
+- name: LOG_LEVEL
+  value: debug
+securityContext:
+  runAsNonRoot: true
+readinessProbe:
+  httpGet:
+    path: /ready
+    port: ${HTTP_PORT}
+volumeMounts:
+- name: config
+  mountPath: /etc/sdk
+`
+
+// testTemplateAllowedArgs are the flags testTemplate may set
+var testTemplateAllowedArgs = []string{"gameserver"}
+
+func TestParseSideCarTemplate(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		data    string
+		invalid bool
+	}{
+		{"valid", testTemplate, false},
+		{"unknown field", "images: sdk:v1", true},
+		{"unknown placeholder", "args: [--port=${PORT}]", true},
+		{"placeholder not expanded", "volumeMounts: [{name: data, mountPath: /data/${POD_NAMESPACE}}]", true},
+		{"placeholder in port name", "ports: [{name: p-${GRPC_PORT}, containerPort: 9000}]", true},
+		{"other name", "name: sdk", true},
+		{"resources", "resources: {limits: {cpu: 500m}}", true},
+		{"image", "image: sdk:v2", true},
+		{"timeout longer than period", "livenessProbe: {httpGet: {port: 9021}, timeoutSeconds: 20}", true},
+		{"liveness success threshold", "livenessProbe: {tcpSocket: {port: 9021}, successThreshold: 2}", true},
+		{"readiness success threshold", "readinessProbe: {tcpSocket: {port: 9021}, successThreshold: 2}", false},
+		{"reserved arg", "args: [--grpc-port=7000]", true},
+		{"arg not allowed", "args: [--debug]", true},
+	} {
+		_, err := ParseSideCarTemplate([]byte(c.data), testTemplateAllowedArgs)
+		if (err != nil) != c.invalid {
+			t.Errorf("%v: desired invalid %v, got %v", c.name, c.invalid, err)
+		}
+	}
+}
+
+func TestWithTemplate(t *testing.T) {
+	template, err := ParseSideCarTemplate([]byte(testTemplate), testTemplateAllowedArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := defaultTestPod().Obj()
+	pod.Namespace = "game"
+	config := &SideCarConfig{Image: "sdk:v1", HttpPort: 9021, GrpcPort: 9020, Probes: DefaultSideCarProbes(),
+		Template: template}
+	mutated, _, _ := MutatePod(pod, nil, nil, config)
+	sideCar := mutated.Spec.Containers[len(mutated.Spec.Containers)-1]
+
+	if sideCar.Args[len(sideCar.Args)-1] != "--gameserver="+pod.Name {
+		t.Errorf("template args should be appended and expanded, got %v", sideCar.Args)
+	}
+	if sideCar.Env[0].Value != "game/"+pod.Name || sideCar.Env[len(sideCar.Env)-1].Name != "LOG_LEVEL" {
+		t.Errorf("template env should replace the built-in one of the same name, got %v", sideCar.Env)
+	}
+	if sideCar.ReadinessProbe == nil || sideCar.ReadinessProbe.HTTPGet.Port != intstr.FromInt(9021) {
+		t.Errorf("readiness probe should use the http port, got %v", sideCar.ReadinessProbe)
+	}
+	if sideCar.LivenessProbe == nil || sideCar.SecurityContext == nil || sideCar.Image != "sdk:v1" {
+		t.Errorf("unset fields of template should keep the built-in ones, got %+v", sideCar)
+	}
+	if len(sideCar.VolumeMounts) == 0 || sideCar.VolumeMounts[len(sideCar.VolumeMounts)-1].MountPath != "/etc/sdk" {
+		t.Errorf("template volume mounts should be added, got %v", sideCar.VolumeMounts)
+	}
+	if !reflect.DeepEqual(template, mustParse(t, testTemplate)) {
+		t.Errorf("template should not be modified by expanding")
+	}
+}
+
+func mustParse(t *testing.T, data string) *corev1.Container {
+	template, err := ParseSideCarTemplate([]byte(data), testTemplateAllowedArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return template
+}
+
+func TestSideCarTemplateWatcher(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "sidecar", Namespace: "kube-system"},
+		Data:       map[string]string{SideCarTemplateKey: "workingDir: /v1"},
+	})
+	w, factory := NewSideCarTemplateWatcher(client, "kube-system", "sidecar", nil)
+	stop := make(chan struct{})
+	defer close(stop)
+	factory.Start(stop)
+
+	waitForWorkingDir := func(dir string) {
+		err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			template := w.Template()
+			if dir == "" {
+				return template == nil, nil
+			}
+			return template != nil && template.WorkingDir == dir, nil
+		})
+		if err != nil {
+			t.Fatalf("desired template working dir %q, got %v", dir, w.Template())
+		}
+	}
+	waitForWorkingDir("/v1")
+
+	configMaps := client.CoreV1().ConfigMaps("kube-system")
+	cm, _ := configMaps.Get(context.TODO(), "sidecar", metav1.GetOptions{})
+	// invalid templates keep the last valid one
+	cm.Data[SideCarTemplateKey] = "workingDirs: /v2"
+	if _, err := configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	cm.Data[SideCarTemplateKey] = "workingDir: /v3"
+	if _, err := configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForWorkingDir("/v3")
+
+	if err := configMaps.Delete(context.TODO(), "sidecar", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForWorkingDir("")
+}
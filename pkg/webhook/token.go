@@ -0,0 +1,154 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// tokenVolumeName is the projected token volume injected if the pod has none
+	tokenVolumeName = "carrier-sdk-api-access"
+	// rootCAConfigMap is published in every namespace by kube-controller-manager
+	rootCAConfigMap = "kube-root-ca.crt"
+	// DefaultTokenExpirationSeconds is the expiration of the projected token, the same as kube-api-access
+	DefaultTokenExpirationSeconds int64 = 3607
+	// MinTokenExpirationSeconds is the minimum expiration accepted by apiserver
+	MinTokenExpirationSeconds int64 = 600
+)
+
+// TokenConfig describes the projected service account token injected for side car
+type TokenConfig struct {
+	// Audience of the token, the apiserver audience if empty
+	Audience string
+	// ExpirationSeconds of the token, DefaultTokenExpirationSeconds if 0
+	ExpirationSeconds int64
+}
+
+// serviceAccountTokenVolume returns the volume of service account token. The volume mounted at the token
+// path by the other containers is preferred, then a projected volume of token, e.g. kube-api-access-*,
+// and then the legacy secret of service account.
+func serviceAccountTokenVolume(pod *corev1.Pod) string {
+	for _, c := range pod.Spec.Containers {
+		for _, m := range c.VolumeMounts {
+			if m.MountPath == mountPath {
+				return m.Name
+			}
+		}
+	}
+	for _, v := range pod.Spec.Volumes {
+		if isProjectedTokenVolume(&v) {
+			return v.Name
+		}
+	}
+	for _, v := range pod.Spec.Volumes {
+		if strings.Contains(v.Name, pod.Spec.ServiceAccountName+"-token") {
+			return v.Name
+		}
+	}
+	return ""
+}
+
+func isProjectedTokenVolume(v *corev1.Volume) bool {
+	if v.Projected == nil {
+		return false
+	}
+	for _, source := range v.Projected.Sources {
+		if source.ServiceAccountToken != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureTokenVolume adds the projected token volume of config if the pod has no service account token,
+// e.g. the ServiceAccount admission plugin is disabled. The plugin runs before the webhook, so a pod with
+// automountServiceAccountToken false, or whose service account has it false, has no token either: the
+// opt-out is honored and a warning is returned instead. serviceAccount may be nil if unknown.
+func ensureTokenVolume(pod *corev1.Pod, serviceAccount *corev1.ServiceAccount, config TokenConfig) string {
+	if serviceAccountTokenVolume(pod) != "" {
+		return ""
+	}
+	if source := automountOptOut(pod, serviceAccount); source != "" {
+		return fmt.Sprintf("automountServiceAccountToken of %v is false, no service account token is mounted "+
+			"into the side car", source)
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, tokenVolume(config))
+	return ""
+}
+
+// automountOptOut returns the object opting out of the service account token, empty if none. The field of
+// pod takes precedence over the one of service account.
+func automountOptOut(pod *corev1.Pod, serviceAccount *corev1.ServiceAccount) string {
+	if automount := pod.Spec.AutomountServiceAccountToken; automount != nil {
+		if *automount {
+			return ""
+		}
+		return "pod"
+	}
+	if serviceAccount != nil && serviceAccount.AutomountServiceAccountToken != nil &&
+		!*serviceAccount.AutomountServiceAccountToken {
+		return "service account " + serviceAccount.Name
+	}
+	return ""
+}
+
+// tokenVolume returns the projected volume of token, ca.crt and namespace like kube-api-access
+func tokenVolume(config TokenConfig) corev1.Volume {
+	expiration := config.ExpirationSeconds
+	if expiration == 0 {
+		expiration = DefaultTokenExpirationSeconds
+	}
+	var mode int32 = 0644
+	return corev1.Volume{
+		Name: tokenVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{
+						ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+							Audience:          config.Audience,
+							ExpirationSeconds: &expiration,
+							Path:              "token",
+						},
+					},
+					{
+						ConfigMap: &corev1.ConfigMapProjection{
+							LocalObjectReference: corev1.LocalObjectReference{Name: rootCAConfigMap},
+							Items:                []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}},
+						},
+					},
+					{
+						DownwardAPI: &corev1.DownwardAPIProjection{
+							Items: []corev1.DownwardAPIVolumeFile{
+								{
+									Path: "namespace",
+									FieldRef: &corev1.ObjectFieldSelector{
+										APIVersion: "v1",
+										FieldPath:  "metadata.namespace",
+									},
+								},
+							},
+						},
+					},
+				},
+				DefaultMode: &mode,
+			},
+		},
+	}
+}
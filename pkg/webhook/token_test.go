@@ -0,0 +1,133 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	carrierutil "github.com/ocgi/carrier/pkg/util"
+)
+
+func tokenTestPod(volumes []corev1.Volume, mounts []corev1.VolumeMount) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "gs-1",
+			Labels: map[string]string{carrierutil.GameServerPodLabelKey: "gs-1"},
+		},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: defaultServiceAccountName,
+			Containers:         []corev1.Container{{Name: "server", VolumeMounts: mounts}},
+			Volumes:            volumes,
+		},
+	}
+}
+
+func TestSideCarTokenVolume(t *testing.T) {
+	projected := tokenVolume(TokenConfig{})
+	projected.Name = "kube-api-access-x7k2p"
+	legacy := corev1.Volume{
+		Name:         "carrier-sdk-token-2kx9e",
+		VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "carrier-sdk-token-2kx9e"}},
+	}
+	data := corev1.Volume{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+	disabled, enabled := false, true
+	optOutPod := tokenTestPod([]corev1.Volume{data}, nil)
+	optOutPod.Spec.AutomountServiceAccountToken = &disabled
+	optInPod := tokenTestPod([]corev1.Volume{data}, nil)
+	optInPod.Spec.AutomountServiceAccountToken = &enabled
+	optOutSA := &corev1.ServiceAccount{
+		ObjectMeta:                   metav1.ObjectMeta{Name: defaultServiceAccountName},
+		AutomountServiceAccountToken: &disabled,
+	}
+	cases := []struct {
+		name           string
+		pod            *corev1.Pod
+		serviceAccount *corev1.ServiceAccount
+		desired        string
+		injected       bool
+		warned         bool
+	}{
+		{
+			name: "projected volume mounted by game server",
+			pod: tokenTestPod([]corev1.Volume{data, projected},
+				[]corev1.VolumeMount{{Name: projected.Name, MountPath: mountPath}}),
+			desired: projected.Name,
+		},
+		{
+			name:    "projected volume not mounted",
+			pod:     tokenTestPod([]corev1.Volume{legacy, projected}, nil),
+			desired: projected.Name,
+		},
+		{
+			name:    "legacy secret",
+			pod:     tokenTestPod([]corev1.Volume{data, legacy}, nil),
+			desired: legacy.Name,
+		},
+		{
+			name:     "no token volume",
+			pod:      tokenTestPod([]corev1.Volume{data}, nil),
+			desired:  tokenVolumeName,
+			injected: true,
+		},
+		{
+			name:   "automount disabled by pod",
+			pod:    optOutPod,
+			warned: true,
+		},
+		{
+			name:           "automount disabled by service account",
+			pod:            tokenTestPod([]corev1.Volume{data}, nil),
+			serviceAccount: optOutSA,
+			warned:         true,
+		},
+		{
+			name:           "automount enabled by pod",
+			pod:            optInPod,
+			serviceAccount: optOutSA,
+			desired:        tokenVolumeName,
+			injected:       true,
+		},
+	}
+	config := testGlobalConfig()
+	config.Token = TokenConfig{Audience: "carrier", ExpirationSeconds: 7200}
+	for _, c := range cases {
+		mutated, _, warnings := MutatePod(c.pod, nil, c.serviceAccount, config)
+		sideCar := mutated.Spec.Containers[len(mutated.Spec.Containers)-1]
+		if c.desired == "" {
+			if len(sideCar.VolumeMounts) != 0 {
+				t.Errorf("%v: desired no mount, got %v", c.name, sideCar.VolumeMounts)
+			}
+		} else if len(sideCar.VolumeMounts) != 1 || sideCar.VolumeMounts[0].Name != c.desired ||
+			sideCar.VolumeMounts[0].MountPath != mountPath {
+			t.Errorf("%v: desired mount of %v, got %v", c.name, c.desired, sideCar.VolumeMounts)
+		}
+		if warned := len(warnings) != 0; warned != c.warned {
+			t.Errorf("%v: desired warned %v, got %v", c.name, c.warned, warnings)
+		}
+		if injected := len(mutated.Spec.Volumes) != len(c.pod.Spec.Volumes); injected != c.injected {
+			t.Errorf("%v: desired volume injected %v, got %v", c.name, c.injected, mutated.Spec.Volumes)
+		}
+		if !c.injected {
+			continue
+		}
+		token := mutated.Spec.Volumes[len(mutated.Spec.Volumes)-1].Projected.Sources[0].ServiceAccountToken
+		if token.Audience != "carrier" || *token.ExpirationSeconds != 7200 {
+			t.Errorf("%v: token should use the configured audience and expiration, got %+v", c.name, token)
+		}
+	}
+}
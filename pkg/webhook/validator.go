@@ -33,19 +33,32 @@ import (
 	carrierv1alpha1 "github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
 )
 
+// templateAnnotationsPath is the path of pod template annotations of GameServerSet and Squad
+var templateAnnotationsPath = field.NewPath("spec", "template", "spec", "template", "metadata", "annotations")
+
 // ValidateGameServer validates the GameServer configuration.
-// If a GameServer is invalid there will be > 0 values in
-// the returned array
-func ValidateGameServer(gs *carrierv1alpha1.GameServer) field.ErrorList {
+// If a GameServer is invalid there will be > 0 errors in
+// the returned result. The side car annotations are validated with config.
+func ValidateGameServer(gs *carrierv1alpha1.GameServer, config *SideCarConfig) *Result {
+	warnings := warningsForSpec(&gs.Spec, field.NewPath("spec"))
 	errs := validateName(gs.ObjectMeta)
 	errs = append(errs, validateSpec(&gs.Spec)...)
 	errs = append(errs, validateContainerName(&gs.Spec.Template)...)
-	return append(errs, validatePodTemplate(&corev1.PodTemplate{Template: gs.Spec.Template})...)
+	// GameServers of a GameServerSet are validated with its template, which may predate this validation
+	if metav1.GetControllerOf(gs) == nil {
+		errs = append(errs, validateSideCarAnnotations(gs.Spec.Template.Annotations, nil, config,
+			field.NewPath("spec", "template", "metadata", "annotations"))...)
+	}
+	errs = append(errs, validatePodTemplate(&corev1.PodTemplate{Template: gs.Spec.Template})...)
+	return &Result{Errors: errs, Warnings: warnings}
 }
 
 // ValidateGameServerUpdate validate the GameServer update, only allow image now.
-func ValidateGameServerUpdate(oldGS, newGS *carrierv1alpha1.GameServer) field.ErrorList {
+func ValidateGameServerUpdate(oldGS, newGS *carrierv1alpha1.GameServer, config *SideCarConfig) *Result {
+	warnings := warningsForSpec(&newGS.Spec, field.NewPath("spec"))
 	errs := validateName(newGS.ObjectMeta)
+	errs = append(errs, validateSideCarAnnotations(newGS.Spec.Template.Annotations, oldGS.Spec.Template.Annotations,
+		config, field.NewPath("spec", "template", "metadata", "annotations"))...)
 	// to support in-place update, allow image update
 	for idx, c := range newGS.Spec.Template.Spec.Containers {
 		oldGS.Spec.Template.Spec.Containers[idx].Image = c.Image
@@ -75,7 +88,7 @@ func ValidateGameServerUpdate(oldGS, newGS *carrierv1alpha1.GameServer) field.Er
 		errs = append(errs, field.Forbidden(field.NewPath("template.spec.template"),
 			"template cannot be updated after creation"))
 	}
-	return errs
+	return &Result{Errors: errs, Warnings: warnings}
 }
 
 // ValidateSpec validates the GameServerSpec configuration.
@@ -144,8 +157,13 @@ func validateLabelsAndAnnotations(objMeta *metav1.ObjectMeta) field.ErrorList {
 }
 
 // ValidateGameServerSetUpdate validate the GameServerSet update, only allow image, pullPolicy and replicas now.
-func ValidateGameServerSetUpdate(oldGSS, newGSS *carrierv1alpha1.GameServerSet) field.ErrorList {
+func ValidateGameServerSetUpdate(oldGSS, newGSS *carrierv1alpha1.GameServerSet,
+	config *SideCarConfig) *Result {
+	// the warnings are checked before the images of newGSS are reset to the old ones
+	warnings := warningsForSpec(&newGSS.Spec.Template.Spec, field.NewPath("spec", "template", "spec"))
 	errs := validateName(newGSS.ObjectMeta)
+	errs = append(errs, validateSideCarAnnotations(newGSS.Spec.Template.Spec.Template.Annotations,
+		oldGSS.Spec.Template.Spec.Template.Annotations, config, templateAnnotationsPath)...)
 	// to support in-place update, allow image update
 	for idx, c := range oldGSS.Spec.Template.Spec.Template.Spec.Containers {
 		newGSS.Spec.Template.Spec.Template.Spec.Containers[idx].Image = c.Image
@@ -159,33 +177,44 @@ func ValidateGameServerSetUpdate(oldGSS, newGSS *carrierv1alpha1.GameServerSet)
 			"GameServer Spec are not allowed to changed, expect for image and resource"))
 	}
 
-	return errs
+	return &Result{Errors: errs, Warnings: warnings}
 }
 
 // ValidateGameServerSet validates when Create occurs, check name, label, annotaions and podSpec
-func ValidateGameServerSet(gsSet *carrierv1alpha1.GameServerSet) field.ErrorList {
+func ValidateGameServerSet(gsSet *carrierv1alpha1.GameServerSet, config *SideCarConfig) *Result {
+	warnings := warningsForSpec(&gsSet.Spec.Template.Spec, field.NewPath("spec", "template", "spec"))
 	errs := validateName(gsSet.ObjectMeta)
 	errs = append(errs, validateSpec(&gsSet.Spec.Template.Spec)...)
 	errs = append(errs, validateLabelsAndAnnotations(&gsSet.Spec.Template.ObjectMeta)...)
 	errs = append(errs, validateContainerName(&gsSet.Spec.Template.Spec.Template)...)
-	return append(errs, validatePodTemplate(&corev1.PodTemplate{ObjectMeta: gsSet.ObjectMeta,
+	errs = append(errs, validateSideCarAnnotations(gsSet.Spec.Template.Spec.Template.Annotations, nil, config,
+		templateAnnotationsPath)...)
+	errs = append(errs, validatePodTemplate(&corev1.PodTemplate{ObjectMeta: gsSet.ObjectMeta,
 		Template: gsSet.Spec.Template.Spec.Template})...)
+	return &Result{Errors: errs, Warnings: warnings}
 }
 
 // ValidateSquad validates when Create occurs, check name, label, annotaions and podSpec
-func ValidateSquad(squad *carrierv1alpha1.Squad) field.ErrorList {
+func ValidateSquad(squad *carrierv1alpha1.Squad, config *SideCarConfig) *Result {
+	warnings := warningsForSpec(&squad.Spec.Template.Spec, field.NewPath("spec", "template", "spec"))
 	errs := validateName(squad.ObjectMeta)
 	errs = append(errs, validateSpec(&squad.Spec.Template.Spec)...)
 	errs = append(errs, validateLabelsAndAnnotations(&squad.Spec.Template.ObjectMeta)...)
 	errs = append(errs, validateContainerName(&squad.Spec.Template.Spec.Template)...)
-	return append(errs, validatePodTemplate(&corev1.PodTemplate{ObjectMeta: squad.ObjectMeta,
+	errs = append(errs, validateSideCarAnnotations(squad.Spec.Template.Spec.Template.Annotations, nil, config,
+		templateAnnotationsPath)...)
+	errs = append(errs, validatePodTemplate(&corev1.PodTemplate{ObjectMeta: squad.ObjectMeta,
 		Template: squad.Spec.Template.Spec.Template})...)
+	return &Result{Errors: errs, Warnings: warnings}
 }
 
 // ValidateSquadUpdate validate the Squad update, only allow image, pullPolicy for pod spec.
 // other fields to controller update policy are all alowed
-func ValidateSquadUpdate(oldSquad, newSquad *carrierv1alpha1.Squad) field.ErrorList {
+func ValidateSquadUpdate(oldSquad, newSquad *carrierv1alpha1.Squad, config *SideCarConfig) *Result {
+	warnings := warningsForSpec(&newSquad.Spec.Template.Spec, field.NewPath("spec", "template", "spec"))
 	errs := validateName(newSquad.ObjectMeta)
+	errs = append(errs, validateSideCarAnnotations(newSquad.Spec.Template.Spec.Template.Annotations,
+		oldSquad.Spec.Template.Spec.Template.Annotations, config, templateAnnotationsPath)...)
 	// to support in-place update, allow image update
 	for idx, c := range newSquad.Spec.Template.Spec.Template.Spec.Containers {
 		oldSquad.Spec.Template.Spec.Template.Spec.Containers[idx].Image = c.Image
@@ -196,7 +225,7 @@ func ValidateSquadUpdate(oldSquad, newSquad *carrierv1alpha1.Squad) field.ErrorL
 			"GameServer Spec are not allowed to changed, expect for image and resource"))
 	}
 
-	return errs
+	return &Result{Errors: errs, Warnings: warnings}
 }
 
 func validatePodTemplate(specTemplate *corev1.PodTemplate) field.ErrorList {
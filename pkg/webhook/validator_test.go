@@ -86,7 +86,7 @@ func Test_ValidateGameServer(t *testing.T) {
 		},
 	} {
 		t.Run(c.name, func(t *testing.T) {
-			errs := ValidateGameServer(c.newGS)
+			errs := ValidateGameServer(c.newGS, testGlobalConfig()).Errors
 			if errs.ToAggregate() == nil != c.ok {
 				t.Errorf("desired %v, get %v, ca", c.ok, errs.ToAggregate())
 				return
@@ -160,7 +160,7 @@ func Test_ValidateGameServerUpdate(t *testing.T) {
 		},
 	} {
 		t.Run(c.name, func(t *testing.T) {
-			errs := ValidateGameServerUpdate(old.Obj(), c.newGS)
+			errs := ValidateGameServerUpdate(old.Obj(), c.newGS, testGlobalConfig()).Errors
 			if errs.ToAggregate() == nil != c.ok {
 				t.Errorf("desired %v, get %v, ca", c.ok, errs.ToAggregate())
 				return
@@ -204,7 +204,7 @@ func Test_ValidateGameServerSetUpdate(t *testing.T) {
 		},
 	} {
 		t.Run(c.name, func(t *testing.T) {
-			errs := ValidateGameServerSetUpdate(old.Obj(), c.newGSS)
+			errs := ValidateGameServerSetUpdate(old.Obj(), c.newGSS, testGlobalConfig()).Errors
 			if errs.ToAggregate() == nil != c.ok {
 				t.Errorf("desired %v, get %v, ca", c.ok, errs.ToAggregate())
 				return
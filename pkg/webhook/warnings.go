@@ -0,0 +1,67 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	carrierv1alpha1 "github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
+)
+
+// warningsForSpec returns the warnings of ports and images of GameServerSpec allowed but probably wrong,
+// it is called by the validators and the side car annotations are validated instead
+func warningsForSpec(spec *carrierv1alpha1.GameServerSpec, fldPath *field.Path) []string {
+	var warnings []string
+	for i, p := range spec.Ports {
+		if p.PortPolicy == carrierv1alpha1.Dynamic && p.HostPortRange != nil {
+			warnings = append(warnings, warning(fldPath.Child("ports").Index(i).Child("hostPortRange"),
+				"is ignored by Dynamic port policy, the host port is allocated by carrier"))
+		}
+	}
+	for i, c := range spec.Template.Spec.Containers {
+		if usesLatestTag(c.Image) {
+			warnings = append(warnings, warning(fldPath.Child("template", "spec", "containers").Index(i).Child("image"),
+				fmt.Sprintf("%q uses the latest tag, game servers of the same set may run different versions", c.Image)))
+		}
+	}
+	return warnings
+}
+
+// warningsForRevisionHistoryLimit warns that the revisionHistoryLimit of Squad is overridden
+func warningsForRevisionHistoryLimit(squad *carrierv1alpha1.Squad, d *Defaults) []string {
+	limit := squad.Spec.RevisionHistoryLimit
+	if limit == nil || *limit == d.RevisionHistoryLimit {
+		return nil
+	}
+	return []string{warning(field.NewPath("spec", "revisionHistoryLimit"),
+		fmt.Sprintf("%v is overridden to %v by the webhook", *limit, d.RevisionHistoryLimit))}
+}
+
+// usesLatestTag returns true if the image has the latest tag or no tag and digest
+func usesLatestTag(image string) bool {
+	if image == "" || strings.Contains(image, "@") {
+		return false
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	i := strings.LastIndex(name, ":")
+	return i < 0 || name[i+1:] == "latest"
+}
+
+func warning(fldPath *field.Path, msg string) string {
+	return fmt.Sprintf("%v: %v", fldPath, msg)
+}
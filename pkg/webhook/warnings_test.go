@@ -0,0 +1,68 @@
+// Copyright 2021 The OCGI Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	carrierv1alpha1 "github.com/ocgi/carrier/pkg/apis/carrier/v1alpha1"
+)
+
+func TestUsesLatestTag(t *testing.T) {
+	for image, desired := range map[string]bool{
+		"nginx":                           true,
+		"nginx:latest":                    true,
+		"registry:5000/game/server":       true,
+		"registry:5000/game/server:v1":    false,
+		"game/server@sha256:abcdef":       false,
+		"game/server:latest@sha256:abcde": false,
+		"":                                false,
+	} {
+		if got := usesLatestTag(image); got != desired {
+			t.Errorf("%q: desired %v, got %v", image, desired, got)
+		}
+	}
+}
+
+func TestValidateSquadWarnings(t *testing.T) {
+	squad := defaultSquad()
+	spec := &squad.Spec.Template.Spec
+	spec.Ports[0].PortPolicy = carrierv1alpha1.Dynamic
+	spec.Ports[0].HostPortRange = &carrierv1alpha1.PortRange{MinPort: 1000, MaxPort: 2000}
+	spec.Template.Spec.Containers = []corev1.Container{{Name: "server", Image: "game:latest"}}
+
+	desired := []string{
+		"spec.template.spec.ports[0].hostPortRange: is ignored by Dynamic port policy, the host port is allocated by carrier",
+		`spec.template.spec.template.spec.containers[0].image: "game:latest" uses the latest tag, game servers of the same set may run different versions`,
+	}
+	if got := ValidateSquad(squad, testGlobalConfig()).Warnings; !reflect.DeepEqual(got, desired) {
+		t.Errorf("desired %v, got %v", desired, got)
+	}
+	old := defaultSquad()
+	old.Spec.Template.Spec.Template.Spec.Containers = []corev1.Container{{Name: "server", Image: "game:v1"}}
+	got := ValidateSquadUpdate(old, squad.DeepCopy(), testGlobalConfig()).Warnings
+	if !reflect.DeepEqual(got, desired) {
+		t.Errorf("update: desired %v, got %v", desired, got)
+	}
+
+	var limit int32 = 3
+	squad.Spec.RevisionHistoryLimit = &limit
+	if got := warningsForRevisionHistoryLimit(squad, NewDefaults()); len(got) != 1 {
+		t.Errorf("overridden revisionHistoryLimit should be warned, got %v", got)
+	}
+}